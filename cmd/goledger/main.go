@@ -0,0 +1,340 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goledger/gains"
+	"github.com/rwcarlsen/goledger/ledger"
+	"github.com/rwcarlsen/goledger/query"
+)
+
+var usage = `Usage: goledger [flags] <command> [query...]
+
+Commands:
+    balance      show account totals
+    register     show postings with a running total
+    holdings     show amounts held per account and lot with their cost basis
+    gains        show realized gains from sales matched against lots
+    unrealized   show unrealized gains of open lots at market prices
+    print        print transactions
+    accounts     list accounts
+    payees       list payees
+    commodities  list commodities
+    prices       list the price history as P directives
+
+A query selects the postings to report on, for example
+
+    goledger -f my.ledger register expenses:food and not payee /walgreens/
+
+Bare words are case-insensitive regular expressions matched against account
+names.  See package query for the full syntax.  Flags:
+`
+
+type fileList []string
+
+func (l *fileList) String() string     { return strings.Join(*l, ",") }
+func (l *fileList) Set(s string) error { *l = append(*l, s); return nil }
+
+var (
+	files  fileList
+	depth  = flag.Int("depth", 0, "limit balance reports to accounts this deep")
+	empty  = flag.Bool("empty", false, "show accounts with zero balances")
+	aux    = flag.Bool("effective", false, "use auxiliary (effective) dates in registers")
+	method = flag.String("method", "fifo", "lot matching method for gains: fifo, lifo, specific or average")
+	pricef = flag.String("price-db", "", "file of P directives with the price history")
+	market string
+	end    = flag.String("end", "", "report only on transactions before this date")
+	at     = flag.String("at", "", "date of the prices used by -X and unrealized (default: the day before -end, else the latest, for balance and unrealized; each posting's for register)")
+)
+
+func init() {
+	const help = "value balance and register reports in this commodity at market prices"
+	flag.StringVar(&market, "X", "", help)
+	flag.StringVar(&market, "market", "", help+" (same as -X)")
+}
+
+// db holds the prices of the journal's P directives and costs and of the
+// -price-db file.
+var db = ledger.NewPriceDB()
+
+type command func(journal []*ledger.Trans, q query.Pred) error
+
+var commands = map[string]command{
+	"balance":     balance,
+	"register":    register,
+	"holdings":    holdings,
+	"gains":       realized,
+	"unrealized":  unrealized,
+	"print":       printJournal,
+	"accounts":    accounts,
+	"payees":      payees,
+	"commodities": commodities,
+	"prices":      prices,
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Var(&files, "f", "journal file to read ('-' for stdin); may be repeated")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		log.Fatalf("unknown command '%v'", flag.Arg(0))
+	}
+
+	if len(files) == 0 {
+		if f := os.Getenv("LEDGER_FILE"); f != "" {
+			files = append(files, f)
+		} else {
+			log.Fatal("no journal file given (use -f or set LEDGER_FILE)")
+		}
+	}
+
+	var journal []*ledger.Trans
+	for _, name := range files {
+		trans, err := load(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		journal = append(journal, trans...)
+	}
+	if err := ledger.CheckBalances(journal); err != nil {
+		log.Fatal(err)
+	}
+	db.AddJournal(journal)
+	if *pricef != "" {
+		if err := db.Load(osFS{}, *pricef); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	q, err := query.Parse(strings.Join(flag.Args()[1:], " "))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *end != "" {
+		date, err := parseDate(*end)
+		if err != nil {
+			log.Fatal(err)
+		}
+		q = before(q, date)
+	}
+	if err := cmd(journal, q); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// load parses the named journal file and the files it includes, recording
+// the prices of their P directives in db.
+func load(name string) ([]*ledger.Trans, error) {
+	pp := &ledger.Parser{FS: osFS{}}
+	var err error
+	if name == "-" {
+		err = pp.Parse("<stdin>", os.Stdin, false)
+	} else {
+		err = pp.Load(name, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.AddDirectives(pp.Directives)
+	return pp.Journal, nil
+}
+
+// osFS opens files by their operating system paths so that journals may
+// be named, and may include files, by absolute or parent-relative paths.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) { return os.Open(name) }
+
+// before selects the items q does in transactions dated before end.
+func before(q query.Pred, end time.Time) query.Pred {
+	return func(t *ledger.Trans, it *ledger.Item) bool {
+		return t.Date.Before(end) && q(t, it)
+	}
+}
+
+// valuation returns the valuation asked for by -X, if any, at the prices of
+// priceDate.  A register values each posting as of its own date unless -at
+// is given.
+func valuation(register bool) (*ledger.Valuation, error) {
+	if market == "" {
+		return nil, nil
+	}
+	var date time.Time
+	if !register || *at != "" {
+		var err error
+		if date, err = priceDate(); err != nil {
+			return nil, err
+		}
+	}
+	return &ledger.Valuation{Prices: db, Commod: market, Date: date}, nil
+}
+
+// priceDate returns the date of the prices reports are valued at: the date
+// given by -at, else the last day before -end, else the zero date for the
+// latest prices.
+func priceDate() (time.Time, error) {
+	switch {
+	case *at != "":
+		return parseDate(*at)
+	case *end != "":
+		date, err := parseDate(*end)
+		return date.AddDate(0, 0, -1), err
+	}
+	return time.Time{}, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	date, err := time.Parse("2006/01/02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%v'; use YYYY/MM/DD", s)
+	}
+	return date, nil
+}
+
+func balance(journal []*ledger.Trans, q query.Pred) error {
+	v, err := valuation(false)
+	if err != nil {
+		return err
+	}
+	opts := &ledger.BalanceOpts{Depth: *depth, HideZero: !*empty, Valuation: v}
+	lines, total, err := ledger.NewAccounts(ledger.Filter(journal, q)).Balance(opts)
+	if err != nil {
+		return err
+	}
+	return ledger.WriteBalance(os.Stdout, lines, total)
+}
+
+func holdings(journal []*ledger.Trans, q query.Pred) error {
+	holdings := ledger.NewAccounts(ledger.Filter(journal, q)).Holdings()
+	return ledger.WriteHoldings(os.Stdout, holdings)
+}
+
+func realized(journal []*ledger.Trans, q query.Pred) error {
+	m, err := gains.ParseMethod(*method)
+	if err != nil {
+		return err
+	}
+	b, err := gains.Build(ledger.Filter(journal, q), m)
+	if err != nil {
+		return err
+	}
+	return gains.WriteSales(os.Stdout, b.Sales)
+}
+
+func unrealized(journal []*ledger.Trans, q query.Pred) error {
+	m, err := gains.ParseMethod(*method)
+	if err != nil {
+		return err
+	}
+	date, err := priceDate()
+	if err != nil {
+		return err
+	}
+	b, err := gains.Build(ledger.Filter(journal, q), m)
+	if err != nil {
+		return err
+	}
+	return gains.WriteUnrealized(os.Stdout, b.Unrealized(db, date))
+}
+
+func register(journal []*ledger.Trans, q query.Pred) error {
+	v, err := valuation(true)
+	if err != nil {
+		return err
+	}
+	lines, err := ledger.Register(ledger.Filter(journal, q), &ledger.RegisterOpts{Aux: *aux, Valuation: v})
+	if err != nil {
+		return err
+	}
+	return ledger.WriteRegister(os.Stdout, lines)
+}
+
+func printJournal(journal []*ledger.Trans, q query.Pred) error {
+	var selected []*ledger.Trans
+	for _, t := range journal {
+		if matches(t, q) {
+			selected = append(selected, t)
+		}
+	}
+	return ledger.Format(os.Stdout, selected, nil)
+}
+
+func matches(t *ledger.Trans, q query.Pred) bool {
+	for _, it := range t.Items {
+		if q(t, it) {
+			return true
+		}
+	}
+	return false
+}
+
+func accounts(journal []*ledger.Trans, q query.Pred) error {
+	names := map[string]bool{}
+	for _, t := range ledger.Filter(journal, q) {
+		for _, it := range t.Items {
+			names[it.Account] = true
+		}
+	}
+	printSorted(names)
+	return nil
+}
+
+func payees(journal []*ledger.Trans, q query.Pred) error {
+	names := map[string]bool{}
+	for _, t := range ledger.Filter(journal, q) {
+		names[t.Descrip] = true
+	}
+	printSorted(names)
+	return nil
+}
+
+func commodities(journal []*ledger.Trans, q query.Pred) error {
+	names := map[string]bool{}
+	for _, t := range ledger.Filter(journal, q) {
+		for _, it := range t.Items {
+			if it.Amount != nil && it.Amount.Commod != "" {
+				names[it.Amount.Commod] = true
+			}
+			if it.Cost != nil && it.Cost.Amount.Commod != "" {
+				names[it.Cost.Amount.Commod] = true
+			}
+		}
+	}
+	printSorted(names)
+	return nil
+}
+
+func prices(journal []*ledger.Trans, q query.Pred) error {
+	for _, p := range db.Prices() {
+		fmt.Println(p)
+	}
+	return nil
+}
+
+func printSorted(set map[string]bool) {
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
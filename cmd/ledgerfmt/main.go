@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rwcarlsen/goledger/ledger"
+)
+
+var (
+	list     = flag.Bool("l", false, "list files whose formatting differs from ledgerfmt's")
+	write    = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	doDiff   = flag.Bool("d", false, "display diffs instead of rewriting files")
+	sortDate = flag.Bool("sort", false, "sort transactions by date")
+	fileWide = flag.Bool("filewide", false, "align amounts across the whole file rather than per transaction")
+	dateFmt  = flag.String("datefmt", "2006/01/02", "Go time layout for transaction dates")
+	indent   = flag.Int("indent", ledger.Tabwidth, "spaces to indent postings with (0 for a tab)")
+)
+
+var exitCode = 0
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 2
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: ledgerfmt [flags] [path ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "error: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		if err := processFile("<standard input>", os.Stdin, os.Stdout); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+
+	for _, path := range flag.Args() {
+		if err := processPath(path); err != nil {
+			report(err)
+		}
+	}
+	os.Exit(exitCode)
+}
+
+func processPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return processFile(path, f, os.Stdout)
+}
+
+func options() *ledger.FormatOpts {
+	opts := &ledger.FormatOpts{DateFmt: *dateFmt, FileWide: *fileWide, Indent: "\t"}
+	if *indent > 0 {
+		opts.Indent = strings.Repeat(" ", *indent)
+	}
+	return opts
+}
+
+func processFile(name string, in io.Reader, out io.Writer) error {
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	f, err := ledger.ParseFile(name, bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+	if *sortDate {
+		f.SortByDate()
+	}
+	if err := f.Format(options()); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		return err
+	}
+	res := buf.Bytes()
+
+	if !bytes.Equal(src, res) {
+		if *list {
+			fmt.Fprintln(out, name)
+		}
+		if *write {
+			info, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(name, res, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if *doDiff {
+			d, err := diff(src, res)
+			if err != nil {
+				return fmt.Errorf("computing diff: %v", err)
+			}
+			fmt.Fprintf(out, "diff %v ledgerfmt/%v\n", name, name)
+			out.Write(d)
+		}
+	}
+
+	if !*list && !*write && !*doDiff {
+		_, err = out.Write(res)
+	}
+	return err
+}
+
+// diff runs the system diff tool on the original and formatted text.
+func diff(b1, b2 []byte) ([]byte, error) {
+	f1, err := writeTemp("ledgerfmt", b1)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+
+	f2, err := writeTemp("ledgerfmt", b2)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+
+	data, err := exec.Command("diff", "-u", f1, f2).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files don't match.
+		// Ignore that failure as long as we get output.
+		err = nil
+	}
+	return data, err
+}
+
+func writeTemp(prefix string, data []byte) (string, error) {
+	f, err := os.CreateTemp("", prefix)
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(name)
+		return "", err
+	}
+	return name, f.Close()
+}
@@ -0,0 +1,316 @@
+// Package gains matches sales of commodities against the lots they were
+// bought in and computes realized and unrealized capital gains.
+//
+// A purchase is an item with a positive amount and a cost, written with @,
+// @@ or a {price} lot annotation.  Each purchase opens a lot in its account
+// whose basis is the {price} if there is one and the cost otherwise.
+// A later negative amount of the same commodity in the same account is a
+// sale and is matched against the open lots by the book's Method.  Its
+// proceeds come from its @ or @@ cost; a sale without one, such as a
+// transfer to another account, is taken at its basis for no gain.  Open
+// lots are valued for their unrealized gains at the prices of a
+// ledger.PriceDB.
+package gains
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/rwcarlsen/goledger/ledger"
+)
+
+const dateFmt = "2006/01/02"
+
+// Method is a way of choosing the lots a sale is taken from.
+type Method int
+
+const (
+	FIFO       Method = iota // oldest lots first
+	LIFO                     // newest lots first
+	SpecificID               // the lot named by the sale's lot annotation
+	Average                  // every lot at their average cost
+)
+
+var methodNames = map[Method]string{
+	FIFO:       "fifo",
+	LIFO:       "lifo",
+	SpecificID: "specific",
+	Average:    "average",
+}
+
+func (m Method) String() string { return methodNames[m] }
+
+// ParseMethod returns the method with the given name: fifo, lifo, specific
+// or average.
+func ParseMethod(name string) (Method, error) {
+	for m, s := range methodNames {
+		if s == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown lot matching method '%v'", name)
+}
+
+// Lot is a purchase of a commodity and how much of it is still held.
+type Lot struct {
+	Account string
+	Amount  ledger.Amount // quantity still held
+	Price   ledger.Amount // cost basis per unit
+	Date    time.Time     // from the lot annotation, else the purchase
+	Note    string
+}
+
+// Basis returns the cost basis of the quantity still held.
+func (l *Lot) Basis() ledger.Amount { return l.Price.Mul(l.Amount.Qty) }
+
+// matches reports whether the lot fits the annotation ann of a sale: every
+// part the annotation gives must agree.
+func (l *Lot) matches(ann *ledger.Lot) bool {
+	if p := ann.Price; p != nil && (p.Commod != l.Price.Commod || p.Qty.Cmp(l.Price.Qty) != 0) {
+		return false
+	} else if !ann.Date.IsZero() && !ann.Date.Equal(l.Date) {
+		return false
+	}
+	return ann.Note == "" || ann.Note == l.Note
+}
+
+// Match is the part of a sale taken from one lot.
+type Match struct {
+	Lot      *Lot
+	Amount   ledger.Amount // quantity taken from the lot
+	Basis    ledger.Amount
+	Proceeds ledger.Amount
+}
+
+// Gain returns the match's proceeds less its basis.
+func (m Match) Gain() ledger.Amount { return m.Proceeds.Add(m.Basis.Neg()) }
+
+// Sale is a sale of a commodity along with the lots it was matched against.
+type Sale struct {
+	Date    time.Time
+	Trans   *ledger.Trans
+	Item    *ledger.Item
+	Matches []Match
+}
+
+// Gain returns the realized gain of the whole sale.
+func (s *Sale) Gain() ledger.Amount {
+	gain := s.Matches[0].Gain()
+	for _, m := range s.Matches[1:] {
+		gain = gain.Add(m.Gain())
+	}
+	return gain
+}
+
+// Book tracks the open lots of every account and the sales made from them.
+type Book struct {
+	Method Method
+	Sales  []*Sale
+	lots   map[string][]*Lot // keyed by account and commodity
+}
+
+// New returns an empty book matching sales by method.
+func New(method Method) *Book {
+	return &Book{Method: method, lots: map[string][]*Lot{}}
+}
+
+// Build records every purchase and sale in journal, taken in date order, in
+// a new book.  The journal's transactions must already be balanced.
+func Build(journal []*ledger.Trans, method Method) (*Book, error) {
+	sorted := append([]*ledger.Trans{}, journal...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+
+	b := New(method)
+	for _, t := range sorted {
+		if err := b.Add(t); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func lotKey(account, commod string) string { return account + "\x00" + commod }
+
+// Add records the purchases and sales in t.  Transactions must be added in
+// date order.
+func (b *Book) Add(t *ledger.Trans) error {
+	for _, it := range t.Items {
+		if it.Amount == nil || it.Amount.IsZero() {
+			continue
+		}
+		key := lotKey(it.Account, it.Amount.Commod)
+		price := it.Price()
+		if lot := it.Amount.Lot; lot != nil && lot.Price != nil {
+			// a lot price is the basis even when the purchase has a cost
+			price = lot.Price
+		}
+		if price != nil && price.Commod == it.Amount.Commod {
+			price = nil
+		}
+
+		if it.Amount.Sign() > 0 && price != nil {
+			b.buy(key, t, it, *price)
+		} else if _, ok := b.lots[key]; ok && it.Amount.Sign() < 0 {
+			if err := b.sell(key, t, it); err != nil {
+				return fmt.Errorf("transaction '%v' on %v: %v", t.Descrip, t.Date.Format(dateFmt), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Book) buy(key string, t *ledger.Trans, it *ledger.Item, price ledger.Amount) {
+	lot := &Lot{Account: it.Account, Amount: *it.Amount, Price: price, Date: t.ItemDate(it, false)}
+	lot.Amount.Lot = nil
+	if ann := it.Amount.Lot; ann != nil {
+		if !ann.Date.IsZero() {
+			lot.Date = ann.Date
+		}
+		lot.Note = ann.Note
+	}
+	b.lots[key] = append(b.lots[key], lot)
+}
+
+func (b *Book) sell(key string, t *ledger.Trans, it *ledger.Item) error {
+	sold := it.Amount.Neg()
+	sold.Lot = nil
+	lots := b.lots[key]
+
+	var candidates []*Lot
+	switch b.Method {
+	case FIFO, Average:
+		candidates = lots
+	case LIFO:
+		for i := len(lots) - 1; i >= 0; i-- {
+			candidates = append(candidates, lots[i])
+		}
+	case SpecificID:
+		if it.Amount.Lot == nil {
+			return fmt.Errorf("sale of %v does not identify a lot", sold)
+		}
+		for _, lot := range lots {
+			if lot.matches(it.Amount.Lot) {
+				candidates = append(candidates, lot)
+			}
+		}
+	}
+
+	held := new(big.Rat)
+	for _, lot := range candidates {
+		held.Add(held, lot.Amount.Qty)
+	}
+	if held.Cmp(sold.Qty) < 0 {
+		return fmt.Errorf("sale of %v exceeds the %v held in matching lots", sold, withQty(sold, held))
+	}
+
+	sale := &Sale{Date: t.ItemDate(it, false), Trans: t, Item: it}
+	remaining := new(big.Rat).Set(sold.Qty)
+	for _, lot := range candidates {
+		if remaining.Sign() == 0 {
+			break
+		}
+		qty := new(big.Rat).Set(lot.Amount.Qty)
+		if b.Method == Average {
+			// take the same fraction of every lot so the average cost of
+			// what remains is unchanged
+			qty.Mul(qty, new(big.Rat).Quo(sold.Qty, held))
+		} else if qty.Cmp(remaining) > 0 {
+			qty.Set(remaining)
+		}
+		if qty.Sign() == 0 {
+			continue
+		}
+		remaining.Sub(remaining, qty)
+
+		amt := withQty(sold, qty)
+		lot.Amount = lot.Amount.Add(amt.Neg())
+		sale.Matches = append(sale.Matches, Match{Lot: lot, Amount: amt, Basis: lot.Price.Mul(qty)})
+	}
+
+	if err := proceeds(sale, sold); err != nil {
+		return err
+	}
+	b.Sales = append(b.Sales, sale)
+	b.prune(key)
+	return nil
+}
+
+func withQty(a ledger.Amount, qty *big.Rat) ledger.Amount {
+	a.Qty = qty
+	return a
+}
+
+// proceeds shares the sale's proceeds among its matches in proportion to
+// their quantity.  A sale with no cost is taken at its basis.
+func proceeds(sale *Sale, sold ledger.Amount) error {
+	price := sale.Item.Price()
+	if sale.Item.Cost == nil {
+		price = nil
+	}
+	for i := range sale.Matches {
+		m := &sale.Matches[i]
+		if price == nil {
+			m.Proceeds = m.Basis
+			continue
+		} else if price.Commod != m.Basis.Commod {
+			return fmt.Errorf("sale of %v is priced in %v but its basis is in %v",
+				sold, price.Commod, m.Basis.Commod)
+		}
+		m.Proceeds = price.Mul(m.Amount.Qty)
+	}
+	return nil
+}
+
+// prune drops the lots under key that have been sold completely.
+func (b *Book) prune(key string) {
+	lots := b.lots[key][:0]
+	for _, lot := range b.lots[key] {
+		if !lot.Amount.IsZero() {
+			lots = append(lots, lot)
+		}
+	}
+	b.lots[key] = lots
+}
+
+// Lots returns the open lots ordered by account, commodity and date.
+func (b *Book) Lots() []*Lot {
+	var lots []*Lot
+	for _, l := range b.lots {
+		lots = append(lots, l...)
+	}
+	sort.SliceStable(lots, func(i, j int) bool {
+		a, b := lots[i], lots[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		} else if a.Amount.Commod != b.Amount.Commod {
+			return a.Amount.Commod < b.Amount.Commod
+		}
+		return a.Date.Before(b.Date)
+	})
+	return lots
+}
+
+// Unrealized is the gain an open lot would realize if sold at a market
+// price.
+type Unrealized struct {
+	Lot   *Lot
+	Value ledger.Amount // market value of the quantity held
+	Gain  ledger.Amount
+}
+
+// Unrealized values the open lots in the commodity of their basis at the
+// market prices in db as of date, or the latest prices if date is zero.
+// Lots that no prices connect to their basis commodity are left out.
+func (b *Book) Unrealized(db *ledger.PriceDB, date time.Time) []Unrealized {
+	var gains []Unrealized
+	for _, lot := range b.Lots() {
+		value, ok := db.Value(lot.Amount, lot.Price.Commod, date)
+		if !ok {
+			continue
+		}
+		gains = append(gains, Unrealized{Lot: lot, Value: value, Gain: value.Add(lot.Basis().Neg())})
+	}
+	return gains
+}
@@ -0,0 +1,163 @@
+package gains
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rwcarlsen/goledger/ledger"
+)
+
+const trades = `
+2023/01/01 Buy
+    Assets:Brokerage    10 AAPL @ $100.00
+    Assets:Checking
+
+2023/06/01 Buy
+    Assets:Brokerage    10 AAPL {$150} [2023/05/30] @ $150
+    Assets:Checking
+
+2023/07/01 Transfer
+    Assets:Checking     $500
+    Income:Salary
+
+2024/01/01 Sell
+    Assets:Brokerage    -15 AAPL @ $200
+    Assets:Checking
+`
+
+func journal(t *testing.T, input string) []*ledger.Trans {
+	journal, err := ledger.Parse("test", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trans := range journal {
+		if err := trans.Balance(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return journal
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		method Method
+		gains  []string // per matched lot
+		open   []string // quantity and basis of the open lots
+	}{
+		{FIFO, []string{"$1000.00", "$250.00"}, []string{"5 AAPL $750.00"}},
+		{LIFO, []string{"$500.00", "$500.00"}, []string{"5 AAPL $500.00"}},
+		// 2.5 AAPL is left in each lot
+		{Average, []string{"$750.00", "$375.00"}, []string{"2.5 AAPL $250.00", "2.5 AAPL $375.00"}},
+	}
+	for _, test := range tests {
+		b, err := Build(journal(t, trades), test.method)
+		if err != nil {
+			t.Errorf("%v: %v", test.method, err)
+			continue
+		}
+		if len(b.Sales) != 1 {
+			t.Errorf("%v: got %v sales, want 1", test.method, len(b.Sales))
+			continue
+		}
+
+		var gains, open []string
+		for _, m := range b.Sales[0].Matches {
+			gains = append(gains, m.Gain().String())
+		}
+		for _, lot := range b.Lots() {
+			open = append(open, lot.Amount.String()+" "+lot.Basis().String())
+		}
+		if strings.Join(gains, ",") != strings.Join(test.gains, ",") {
+			t.Errorf("%v: got gains %v, want %v", test.method, gains, test.gains)
+		}
+		if strings.Join(open, ",") != strings.Join(test.open, ",") {
+			t.Errorf("%v: got open lots %v, want %v", test.method, open, test.open)
+		}
+	}
+}
+
+func TestSpecificID(t *testing.T) {
+	const sale = `
+2024/02/01 Sell
+    Assets:Brokerage    -4 AAPL {$150} @ $120
+    Assets:Checking
+`
+	b, err := Build(journal(t, strings.Replace(trades, "-15 AAPL", "-1 AAPL [2023/05/30]", 1)+sale), SpecificID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Sales[0].Gain().String(); got != "$50.00" {
+		t.Errorf("got gain %v on the first sale, want $50.00", got)
+	}
+	if got := b.Sales[1].Gain().String(); got != "$-120.00" {
+		t.Errorf("got gain %v on the second sale, want $-120.00", got)
+	}
+
+	if _, err := Build(journal(t, trades), SpecificID); err == nil {
+		t.Errorf("expected an error for a sale without a lot annotation")
+	}
+	if _, err := Build(journal(t, strings.Replace(trades, "-15 AAPL", "-15 AAPL {$150}", 1)), SpecificID); err == nil {
+		t.Errorf("expected an error for a sale larger than its lot")
+	}
+
+	const costed = `
+2024/01/05 Buy
+    Assets:Brokerage    10 AAPL {$150} @ $155
+    Assets:Checking
+
+2024/03/01 Sell
+    Assets:Brokerage    -10 AAPL {$150} @ $170
+    Assets:Checking
+`
+	b, err = Build(journal(t, costed), SpecificID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Sales[0].Gain().String(); got != "$200" {
+		t.Errorf("got gain %v, want $200 over the lot price", got)
+	}
+}
+
+func TestReports(t *testing.T) {
+	b, err := Build(journal(t, trades), FIFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSales(&buf, b.Sales); err != nil {
+		t.Fatal(err)
+	}
+	want := `
+2024/01/01  Assets:Brokerage  10 AAPL  2023/01/01  $2000.00  $1000.00  $1000.00
+2024/01/01  Assets:Brokerage   5 AAPL  2023/05/30  $1000.00   $750.00   $250.00
+`
+	if got := "\n" + buf.String(); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+
+	// the sale prices AAPL at $200 until a later price of $180
+	db := ledger.NewPriceDB()
+	db.AddJournal(journal(t, trades))
+	db.Add(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), "AAPL", b.Lots()[0].Price.Mul(big.NewRat(6, 5)))
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{time.Time{}, "Assets:Brokerage  5 AAPL  2023/05/30  $750.00  $900.00  $150.00\n"},
+		{time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), "Assets:Brokerage  5 AAPL  2023/05/30  $750.00  $1000.00  $250.00\n"},
+		{time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), ""},
+	}
+	for _, test := range tests {
+		buf.Reset()
+		if err := WriteUnrealized(&buf, b.Unrealized(db, test.date)); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("as of %v got\n%v\nwant\n%v", test.date, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package gains
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/rwcarlsen/goledger/ledger"
+)
+
+// WriteSales writes a line for every lot a sale was matched against: the
+// date sold, account, quantity, date acquired, proceeds, basis and gain.
+func WriteSales(w io.Writer, sales []*Sale) error {
+	var rows [][]string
+	for _, s := range sales {
+		for _, m := range s.Matches {
+			rows = append(rows, []string{
+				s.Date.Format(dateFmt),
+				m.Lot.Account,
+				m.Amount.String(),
+				m.Lot.Date.Format(dateFmt),
+				m.Proceeds.String(),
+				m.Basis.String(),
+				m.Gain().String(),
+			})
+		}
+	}
+	return writeTable(w, rows, 2, 4, 5, 6)
+}
+
+// WriteUnrealized writes a line for every valued lot: its account,
+// quantity, date acquired, basis, market value and unrealized gain.
+func WriteUnrealized(w io.Writer, gains []Unrealized) error {
+	var rows [][]string
+	for _, g := range gains {
+		rows = append(rows, []string{
+			g.Lot.Account,
+			g.Lot.Amount.String(),
+			g.Lot.Date.Format(dateFmt),
+			g.Lot.Basis().String(),
+			g.Value.String(),
+			g.Gain.String(),
+		})
+	}
+	return writeTable(w, rows, 1, 3, 4, 5)
+}
+
+// writeTable writes rows as tab-aligned columns with the amounts in the
+// columns numbered right aligned.
+func writeTable(w io.Writer, rows [][]string, right ...int) error {
+	for _, col := range right {
+		width := 0
+		for _, row := range rows {
+			if n := utf8.RuneCountInString(row[col]); n > width {
+				width = n
+			}
+		}
+		for _, row := range rows {
+			row[col] = strings.Repeat(" ", width-utf8.RuneCountInString(row[col])) + row[col]
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, ledger.Minwidth, ledger.Tabwidth, ledger.Padding, ledger.Padchar, 0)
+	for _, row := range rows {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,219 @@
+package ledger
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// AccountSep separates the components of hierarchical account names.
+const AccountSep = ":"
+
+// Account is a node in the account hierarchy.  Amounts holds the
+// per-commodity sums of items posted directly to the account and Totals
+// additionally rolls up the amounts of all its descendants.
+type Account struct {
+	Name     string // last component of the account name
+	FullName string
+	Parent   *Account
+	Children []*Account
+	Amounts  Balance
+	Totals   Balance
+}
+
+func newAccount(name, full string, parent *Account) *Account {
+	return &Account{
+		Name:     name,
+		FullName: full,
+		Parent:   parent,
+		Amounts:  Balance{},
+		Totals:   Balance{},
+	}
+}
+
+// Depth returns the number of components in the account's name.  The root
+// has depth zero.
+func (a *Account) Depth() int {
+	n := 0
+	for p := a.Parent; p != nil; p = p.Parent {
+		n++
+	}
+	return n
+}
+
+// Accounts is an account hierarchy accumulated from transaction items.
+type Accounts struct {
+	Root  *Account
+	index map[string]*Account
+}
+
+// NewAccounts builds the account hierarchy for journal.  Items without an
+// amount are skipped, so transactions should usually be balanced first.
+func NewAccounts(journal []*Trans) *Accounts {
+	a := &Accounts{Root: newAccount("", "", nil), index: map[string]*Account{}}
+	for _, t := range journal {
+		for _, it := range t.Items {
+			a.Add(it)
+		}
+	}
+	return a
+}
+
+// Add posts it to its account and all the account's ancestors.
+func (a *Accounts) Add(it *Item) {
+	if it.Amount != nil {
+		a.add(it.Account, *it.Amount)
+	}
+}
+
+func (a *Accounts) add(name string, amt Amount) {
+	acct := a.get(name)
+	acct.Amounts.Add(amt)
+	for ; acct != nil; acct = acct.Parent {
+		acct.Totals.Add(amt)
+	}
+}
+
+// Find returns the account with the given full name or nil if it doesn't
+// exist.
+func (a *Accounts) Find(name string) *Account {
+	return a.index[name]
+}
+
+// get returns the named account, creating it and any missing ancestors.
+func (a *Accounts) get(name string) *Account {
+	if acct, ok := a.index[name]; ok {
+		return acct
+	}
+
+	parent := a.Root
+	if i := strings.LastIndex(name, AccountSep); i >= 0 {
+		parent = a.get(name[:i])
+	}
+	acct := newAccount(name[strings.LastIndex(name, AccountSep)+1:], name, parent)
+
+	i := sort.Search(len(parent.Children), func(i int) bool {
+		return parent.Children[i].Name >= acct.Name
+	})
+	parent.Children = append(parent.Children, nil)
+	copy(parent.Children[i+1:], parent.Children[i:])
+	parent.Children[i] = acct
+
+	a.index[name] = acct
+	return acct
+}
+
+// BalanceOpts controls which accounts a balance report includes.
+type BalanceOpts struct {
+	// Depth limits the report to accounts at most this many levels deep.
+	// Deeper accounts are rolled up into their ancestors.  Zero means no
+	// limit.
+	Depth int
+	// HideZero omits accounts whose totals are zero in every commodity.
+	HideZero bool
+	// Pattern is a case-insensitive regular expression.  If non-empty,
+	// only amounts posted to accounts with matching full names are
+	// reported.
+	Pattern string
+	// Valuation, if set, values each account's totals in a single
+	// commodity, as of its Date or else at the latest prices.
+	Valuation *Valuation
+}
+
+// BalanceLine is a single account in a balance report.
+type BalanceLine struct {
+	Account *Account
+	Depth   int
+	Totals  Balance
+}
+
+// Balance returns the accounts to show in a balance report in depth-first
+// order, along with the grand total.
+func (a *Accounts) Balance(opts *BalanceOpts) ([]BalanceLine, Balance, error) {
+	if opts == nil {
+		opts = &BalanceOpts{}
+	}
+
+	src := a
+	if opts.Pattern != "" {
+		re, err := regexp.Compile("(?i)" + opts.Pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		src = &Accounts{Root: newAccount("", "", nil), index: map[string]*Account{}}
+		for name, acct := range a.index {
+			if !re.MatchString(name) {
+				continue
+			}
+			for _, amt := range acct.Amounts {
+				src.add(name, amt)
+			}
+		}
+	}
+
+	var lines []BalanceLine
+	var walk func(acct *Account)
+	walk = func(acct *Account) {
+		depth := acct.Depth()
+		if opts.Depth > 0 && depth > opts.Depth {
+			return
+		}
+		if depth > 0 && !(opts.HideZero && acct.Totals.IsZero()) {
+			lines = append(lines, BalanceLine{Account: acct, Depth: depth, Totals: opts.value(acct.Totals)})
+		}
+		for _, child := range acct.Children {
+			walk(child)
+		}
+	}
+	walk(src.Root)
+	return lines, opts.value(src.Root.Totals), nil
+}
+
+func (opts *BalanceOpts) value(b Balance) Balance {
+	if opts.Valuation == nil {
+		return b
+	}
+	return opts.Valuation.balance(b, opts.Valuation.Date)
+}
+
+// WriteBalance writes lines and total in the style of ledger's balance
+// command: one line per commodity with the account name indented under its
+// parent and the total below a separator.
+func WriteBalance(w io.Writer, lines []BalanceLine, total Balance) error {
+	width := 0
+	for _, line := range lines {
+		for _, s := range line.Totals.Strings() {
+			width = maxWidth(width, s)
+		}
+	}
+
+	for _, line := range lines {
+		amts := line.Totals.Strings()
+		for i, s := range amts {
+			name := ""
+			if i == len(amts)-1 {
+				name = strings.Repeat("  ", line.Depth-1) + line.Account.Name
+			}
+			if err := writeRow(w, width, s, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := fmt.Fprintln(w, strings.Repeat("-", width)); err != nil {
+		return err
+	}
+	for _, s := range total.Strings() {
+		if err := writeRow(w, width, s, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeRow(w io.Writer, width int, amt, name string) error {
+	_, err := fmt.Fprintln(w, strings.TrimRight(padLeft(amt, width)+"  "+name, " "))
+	return err
+}
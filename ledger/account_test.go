@@ -0,0 +1,102 @@
+package ledger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const journal2 = `
+10/06/01 Grocery store
+    Expenses:Food:Groceries      $42.10
+    Assets:Checking
+
+10/06/02 Diner
+    Expenses:Food:Dining         $12.50
+    Liabilities:Credit card
+
+10/06/03 Gas station
+    Expenses:Auto:Fuel           $30
+    Assets:Checking
+
+10/06/03 Fill up
+    Expenses:Auto:Fuel           10 GAL
+    Assets:Fuel tank
+
+10/06/04 Card payment
+    Liabilities:Credit card      $12.50
+    Assets:Checking
+`
+
+func balanced(t *testing.T, input string) []*Trans {
+	journal, err := Parse("test", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trans := range journal {
+		if err := trans.Balance(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return journal
+}
+
+var balanceTests = []struct {
+	opts *BalanceOpts
+	want string
+}{
+	{
+		&BalanceOpts{},
+		`
+$-84.60
+-10 GAL  Assets
+$-84.60    Checking
+-10 GAL    Fuel tank
+ $84.60
+ 10 GAL  Expenses
+ $30.00
+ 10 GAL    Auto
+ $30.00
+ 10 GAL      Fuel
+ $54.60    Food
+ $12.50      Dining
+ $42.10      Groceries
+      0  Liabilities
+      0    Credit card
+-------
+      0
+`,
+	},
+	{
+		&BalanceOpts{Depth: 1, HideZero: true, Pattern: "^expenses:food|^assets"},
+		`
+$-84.60
+-10 GAL  Assets
+ $54.60  Expenses
+-------
+$-30.00
+-10 GAL
+`,
+	},
+}
+
+func TestAccountsBalance(t *testing.T) {
+	accts := NewAccounts(balanced(t, journal2))
+	if acct := accts.Find("Expenses:Food"); acct == nil || acct.Totals["$"].String() != "$54.60" {
+		t.Errorf("bad Expenses:Food account: %+v", acct)
+	}
+
+	for i, test := range balanceTests {
+		lines, total, err := accts.Balance(test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteBalance(&buf, lines, total); err != nil {
+			t.Fatal(err)
+		}
+		if got := "\n" + buf.String(); got != test.want {
+			t.Errorf("test %v: got\n%v\nwant\n%v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package ledger
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Style describes how amounts of a commodity are written.  The parser
+// learns a style for each commodity from the way its amounts appear in the
+// input: the first amount decides placement, separators come from the first
+// amounts that show them and the most precise sets the precision.
+type Style struct {
+	Prefix    bool   // commodity written before the quantity
+	Space     bool   // space between commodity and quantity
+	Thousands string // thousands separator; empty for none
+	Decimal   string // decimal mark; "." if empty
+	Precision int    // digits after the decimal mark
+}
+
+// Amount is a quantity of a commodity.  Arithmetic is exact; the style only
+// affects how the amount is formatted.  Amounts are values: methods return
+// new amounts rather than modifying their receiver.
+type Amount struct {
+	Qty    *big.Rat
+	Commod string
+	Style  *Style // nil for a default style
+	Lot    *Lot   // nil if the amount isn't annotated with a lot
+}
+
+// Lot identifies the purchase an amount of a commodity came from.  It is
+// written after the amount as "10 AAPL {$150} [2023/05/01] (note)" where each
+// annotation is optional.  Amounts from different lots are kept apart in
+// balances.
+type Lot struct {
+	Price *Amount   // cost basis per unit; nil if not given
+	Date  time.Time // zero if not given
+	Note  string
+}
+
+func (l *Lot) String() string {
+	var s string
+	if l.Price != nil {
+		s += " {" + l.Price.String() + "}"
+	}
+	if !l.Date.IsZero() {
+		s += " [" + l.Date.Format(dateFmt) + "]"
+	}
+	if l.Note != "" {
+		s += " (" + l.Note + ")"
+	}
+	return s
+}
+
+// key identifies the amount's commodity and lot exactly.
+func (a Amount) key() string {
+	if a.Lot == nil {
+		return a.Commod
+	}
+	k := a.Commod + "\x00"
+	if p := a.Lot.Price; p != nil {
+		k += p.Commod + " " + p.qty().RatString()
+	}
+	if !a.Lot.Date.IsZero() {
+		k += "\x00" + a.Lot.Date.Format(dateFmt)
+	}
+	return k + "\x00" + a.Lot.Note
+}
+
+// NewAmount returns an amount of qty in commod with the default style.
+func NewAmount(qty *big.Rat, commod string) Amount {
+	return Amount{Qty: qty, Commod: commod}
+}
+
+func (a Amount) qty() *big.Rat {
+	if a.Qty == nil {
+		return new(big.Rat)
+	}
+	return a.Qty
+}
+
+func (a Amount) with(qty *big.Rat) Amount {
+	a.Qty = qty
+	return a
+}
+
+// Add returns a+b.  b must be in the same commodity as a; only its quantity
+// is used.
+func (a Amount) Add(b Amount) Amount {
+	return a.with(new(big.Rat).Add(a.qty(), b.qty()))
+}
+
+// Neg returns -a.
+func (a Amount) Neg() Amount {
+	return a.with(new(big.Rat).Neg(a.qty()))
+}
+
+// Mul returns a multiplied by r.
+func (a Amount) Mul(r *big.Rat) Amount {
+	return a.with(new(big.Rat).Mul(a.qty(), r))
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of a's quantity.
+func (a Amount) Sign() int { return a.qty().Sign() }
+
+// IsZero reports whether a's quantity is zero.
+func (a Amount) IsZero() bool { return a.Sign() == 0 }
+
+// String formats a according to its style, followed by its lot annotations.
+// The quantity is written exactly if it is a short enough decimal, with at
+// least the style's precision.  Without a style, symbol commodities like "$"
+// are written before the quantity and named ones like "AAPL" after it.
+func (a Amount) String() string {
+	style := a.Style
+	if style == nil {
+		style = defaultStyle(a.Commod)
+	}
+
+	// a style's precision pads the quantity but never rounds away digits
+	num := decimal(a.qty())
+	if s := a.qty().FloatString(style.Precision); a.Style != nil && len(s) >= len(num) {
+		num = s
+	}
+	num = style.number(num)
+
+	commod := quoteCommod(a.Commod)
+	switch {
+	case commod == "":
+	case style.Prefix && style.Space:
+		num = commod + " " + num
+	case style.Prefix:
+		num = commod + num
+	case style.Space:
+		num = num + " " + commod
+	default:
+		num = num + commod
+	}
+	if a.Lot != nil {
+		num += a.Lot.String()
+	}
+	return num
+}
+
+// quoteCommod quotes a commodity that could not be read back unquoted, like
+// "S&P 500".
+func quoteCommod(commod string) string {
+	if strings.ContainsAny(commod, commodStop) {
+		return `"` + commod + `"`
+	}
+	return commod
+}
+
+func defaultStyle(commod string) *Style {
+	r, n := utf8.DecodeRuneInString(commod)
+	if n == len(commod) && !unicode.IsLetter(r) {
+		return &Style{Prefix: true}
+	}
+	return &Style{Space: true}
+}
+
+// number rewrites a plain decimal number like -1234.5 with the style's
+// thousands separator and decimal mark.  With ',' as the decimal mark, a
+// number that would read as the ambiguous d,ddd or d.ddd, which the parser
+// takes to use '.', is written another way: a decimal part gets a fourth
+// digit and a lone thousands separator is left out.
+func (s *Style) number(num string) string {
+	sign := ""
+	if strings.HasPrefix(num, "-") {
+		sign, num = "-", num[1:]
+	}
+	whole, frac := num, ""
+	if i := strings.IndexByte(num, '.'); i >= 0 {
+		whole, frac = num[:i], num[i+1:]
+	}
+
+	comma := s.Decimal == ","
+	if comma && len(frac) == 3 && (s.Thousands == "" || len(whole) <= 3) {
+		frac += "0"
+	}
+	if s.Thousands != "" && !(comma && frac == "" && len(whole) > 3 && len(whole) <= 6) {
+		var groups []string
+		for len(whole) > 3 {
+			groups = append([]string{whole[len(whole)-3:]}, groups...)
+			whole = whole[:len(whole)-3]
+		}
+		whole = strings.Join(append([]string{whole}, groups...), s.Thousands)
+	}
+	if frac == "" {
+		return sign + whole
+	}
+	mark := s.Decimal
+	if mark == "" {
+		mark = "."
+	}
+	return sign + whole + mark + frac
+}
+
+// learn merges what an amount written in style t reveals into s.
+func (s *Style) learn(t Style) {
+	if t.Precision > s.Precision {
+		s.Precision = t.Precision
+	}
+	if s.Decimal == "" {
+		s.Decimal = t.Decimal
+	}
+	if s.Thousands == "" {
+		s.Thousands = t.Thousands
+	}
+}
+
+// Balance is a sum of amounts in any number of commodities, keyed by
+// commodity.  Amounts in lots of a commodity have keys of their own.
+type Balance map[string]Amount
+
+// Add adds a to the balance.
+func (b Balance) Add(a Amount) {
+	k := a.key()
+	if cur, ok := b[k]; ok {
+		b[k] = cur.Add(a)
+	} else {
+		b[k] = a.with(new(big.Rat).Set(a.qty()))
+	}
+}
+
+// AddBalance adds every amount in o to the balance.
+func (b Balance) AddBalance(o Balance) {
+	for _, a := range o {
+		b.Add(a)
+	}
+}
+
+// Neg returns the negation of the balance.
+func (b Balance) Neg() Balance {
+	neg := make(Balance, len(b))
+	for c, a := range b {
+		neg[c] = a.Neg()
+	}
+	return neg
+}
+
+// Copy returns a copy of the balance.
+func (b Balance) Copy() Balance {
+	cp := make(Balance, len(b))
+	cp.AddBalance(b)
+	return cp
+}
+
+// IsZero reports whether the balance is zero in every commodity.
+func (b Balance) IsZero() bool {
+	for _, a := range b {
+		if !a.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// qty returns the balance's quantity of commod summed over all its lots.
+func (b Balance) qty(commod string) *big.Rat {
+	sum := new(big.Rat)
+	for _, a := range b {
+		if a.Commod == commod {
+			sum.Add(sum, a.qty())
+		}
+	}
+	return sum
+}
+
+// Amounts returns the balance's non-zero amounts sorted by commodity, with
+// the lots of a commodity in date order.
+func (b Balance) Amounts() []Amount {
+	var amts []Amount
+	for _, a := range b {
+		if !a.IsZero() {
+			amts = append(amts, a)
+		}
+	}
+	sort.Slice(amts, func(i, j int) bool { return amts[i].less(amts[j]) })
+	return amts
+}
+
+func (a Amount) less(b Amount) bool {
+	if a.Commod != b.Commod {
+		return a.Commod < b.Commod
+	}
+	var ad, bd time.Time
+	if a.Lot != nil {
+		ad = a.Lot.Date
+	}
+	if b.Lot != nil {
+		bd = b.Lot.Date
+	}
+	if !ad.Equal(bd) {
+		return ad.Before(bd)
+	}
+	return a.key() < b.key()
+}
+
+// Strings formats each of the balance's non-zero amounts.  A balance of zero
+// is formatted as a single "0".
+func (b Balance) Strings() []string {
+	amts := b.Amounts()
+	if len(amts) == 0 {
+		return []string{"0"}
+	}
+	s := make([]string, len(amts))
+	for i, a := range amts {
+		s[i] = a.String()
+	}
+	return s
+}
+
+func (b Balance) String() string {
+	return strings.Join(b.Strings(), ", ")
+}
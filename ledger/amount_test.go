@@ -0,0 +1,74 @@
+package ledger
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const journal5 = `
+2024/01/05 Landlord
+    Expenses:Rent       $1,234.5
+    Assets:Checking
+
+2024/01/06 Broker
+    Assets:Brokerage    2.125 AAPL
+    Assets:Checking     $10.25
+    Income:Gifts
+`
+
+func TestAmountStyle(t *testing.T) {
+	journal, err := Parse("journal5", strings.NewReader(journal5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trans := range journal {
+		if err := trans.Balance(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// quantities needing more digits than their style gives are not rounded
+	halfAAPL := journal[1].Items[0].Amount.Mul(big.NewRat(1, 2))
+	halfCash := journal[1].Items[1].Amount.Mul(big.NewRat(1, 2))
+
+	tests := []struct {
+		amt  *Amount
+		want string
+	}{
+		{journal[0].Items[0].Amount, "$1,234.50"},
+		{journal[0].Items[1].Amount, "$-1,234.50"},
+		{journal[1].Items[0].Amount, "2.125 AAPL"},
+		{journal[1].Items[1].Amount, "$10.25"},
+		{&halfAAPL, "1.0625 AAPL"},
+		{&halfCash, "$5.125"},
+	}
+	for i, test := range tests {
+		if got := test.amt.String(); got != test.want {
+			t.Errorf("amount %v: got %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestBalanceType(t *testing.T) {
+	b := Balance{}
+	if !b.IsZero() || b.String() != "0" {
+		t.Errorf("empty balance: got %q", b)
+	}
+
+	b.Add(NewAmount(big.NewRat(5, 2), "$"))
+	b.Add(NewAmount(big.NewRat(3, 1), "AAPL"))
+	b.Add(NewAmount(big.NewRat(-1, 2), "$"))
+	if got, want := b.String(), "$2, 3 AAPL"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	sum := b.Copy()
+	sum.AddBalance(b.Neg())
+	if !sum.IsZero() {
+		t.Errorf("balance minus itself: got %q, want 0", sum)
+	}
+	if b.IsZero() {
+		t.Errorf("copy shares amounts with the original: %q", b)
+	}
+}
@@ -0,0 +1,171 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Balance fills in the amount of the transaction's elided item (one written
+// without an amount) so that every commodity sums to zero.  If more than one
+// commodity needs balancing, the elided item is split into one item per
+// commodity.  Items with a cost count at their cost, so "10 AAPL @ $150"
+// balances against $1500.  An error is returned if more than one item is
+// elided or if the transaction doesn't balance and has no elided item to
+// absorb the difference.
+func (t *Trans) Balance() error {
+	var commods []string
+	sums := Balance{}
+	elided := -1
+	for i, it := range t.Items {
+		if it.Amount == nil && it.Assert != nil {
+			return t.errorf("assigns the balance of %v, which needs CheckBalances", it.Account)
+		} else if it.Amount == nil {
+			if elided >= 0 {
+				return t.errorf("more than one item with no amount")
+			}
+			elided = i
+			continue
+		}
+
+		// lots are kept apart in accounts but not in the zero sum
+		amt := *it.Amount
+		if cost := it.TotalCost(); cost != nil {
+			amt = *cost
+		}
+		amt.Lot = nil
+		if _, ok := sums[amt.Commod]; !ok {
+			commods = append(commods, amt.Commod)
+		}
+		sums.Add(amt)
+	}
+
+	var fill []*Item
+	for _, c := range commods {
+		sum := sums[c]
+		if sum.IsZero() {
+			continue
+		} else if elided < 0 {
+			return t.errorf("does not balance: off by %v", sum)
+		}
+		it := *t.Items[elided]
+		neg := sum.Neg()
+		it.Amount = &neg
+		fill = append(fill, &it)
+	}
+
+	if elided < 0 {
+		return nil
+	} else if len(fill) == 0 {
+		zero := NewAmount(new(big.Rat), "")
+		t.Items[elided].Amount = &zero
+		return nil
+	}
+
+	items := append([]*Item{}, t.Items[:elided]...)
+	items = append(items, fill...)
+	t.Items = append(items, t.Items[elided+1:]...)
+	return nil
+}
+
+// AssertionError is a balance assertion that doesn't hold.
+type AssertionError struct {
+	Trans  *Trans
+	Item   *Item
+	Actual Amount // the account's balance in the asserted commodity
+}
+
+func (e *AssertionError) Error() string {
+	it := e.Item
+	diff := e.Actual.Add(it.Assert.Neg())
+	msg := fmt.Sprintf("balance of %v is %v, not %v (off by %v)", it.Account, e.Actual, it.Assert, diff)
+	if e.Trans.File != "" {
+		msg = fmt.Sprintf("%v:%v: %v", e.Trans.File, it.Line, msg)
+	}
+	return msg
+}
+
+// AssertionErrors lists the failed balance assertions of a journal in file
+// order.
+type AssertionErrors []*AssertionError
+
+func (l AssertionErrors) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// CheckBalances balances every transaction in journal, taken in file order,
+// while keeping a running balance of each account.  Balance assignments are
+// given the amount that brings their account to the assigned balance, and
+// balance assertions are checked against the running balance of their
+// account alone, not counting its sub-accounts.  A transaction that doesn't
+// balance stops the check with its error; otherwise the failed assertions
+// are returned as AssertionErrors.
+func CheckBalances(journal []*Trans) error {
+	running := map[string]Balance{}
+	var errs AssertionErrors
+	for _, t := range journal {
+		for i, it := range t.Items {
+			if it.Amount != nil || it.Assert == nil {
+				continue
+			}
+			bal := running[it.Account].Copy()
+			for _, prev := range t.Items[:i] {
+				if prev.Account == it.Account && prev.Amount != nil {
+					bal.Add(*prev.Amount)
+				}
+			}
+			amt := it.Assert.with(new(big.Rat).Sub(it.Assert.qty(), bal.qty(it.Assert.Commod)))
+			it.Amount = &amt
+		}
+
+		if err := t.Balance(); err != nil {
+			return err
+		}
+
+		for _, it := range t.Items {
+			if running[it.Account] == nil {
+				running[it.Account] = Balance{}
+			}
+			running[it.Account].Add(*it.Amount)
+			if it.Assert == nil {
+				continue
+			}
+			actual := it.Assert.with(running[it.Account].qty(it.Assert.Commod))
+			if actual.Qty.Cmp(it.Assert.qty()) != 0 {
+				errs = append(errs, &AssertionError{Trans: t, Item: it, Actual: actual})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// errorf returns an error about t prefixed with its location, if known, and
+// identified by its payee and date.
+func (t *Trans) errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf("transaction '%v' on %v ", t.Descrip, t.Date.Format(dateFmt)) + fmt.Sprintf(format, args...)
+	if t.File != "" {
+		msg = fmt.Sprintf("%v:%v: %v", t.File, t.Line, msg)
+	}
+	return errors.New(msg)
+}
+
+// decimal formats r exactly if it has a terminating decimal expansion of a
+// reasonable length and rounds it otherwise.
+func decimal(r *big.Rat) string {
+	const maxPrec = 10
+	for prec := 0; prec < maxPrec; prec++ {
+		s := r.FloatString(prec)
+		if v, _ := new(big.Rat).SetString(s); v.Cmp(r) == 0 {
+			return s
+		}
+	}
+	return r.FloatString(maxPrec)
+}
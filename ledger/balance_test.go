@@ -0,0 +1,191 @@
+package ledger
+
+import (
+	"strings"
+	"testing"
+)
+
+const unbalanced = `
+10/06/01 Groceries
+    Expenses:Food      $10.25
+    Expenses:Fuel      20 GAL
+    Assets:Checking
+    Assets:Cash        $0.75
+
+10/06/02 Lunch
+    Expenses:Food      $10
+    Assets:Checking    $9.99
+
+10/06/03 Two elided
+    Expenses:Food      $10
+    Assets:Checking
+    Assets:Cash
+`
+
+func TestBalance(t *testing.T) {
+	journal, err := Parse("unbalanced", strings.NewReader(unbalanced))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trans := journal[0]
+	if err := trans.Balance(); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ account, amount, commod string }{
+		{"Expenses:Food", "10.25", "$"},
+		{"Expenses:Fuel", "20", "GAL"},
+		{"Assets:Checking", "-11", "$"},
+		{"Assets:Checking", "-20", "GAL"},
+		{"Assets:Cash", "0.75", "$"},
+	}
+	if len(trans.Items) != len(want) {
+		t.Fatalf("got %v items, want %v", len(trans.Items), len(want))
+	}
+	for i, w := range want {
+		it := trans.Items[i]
+		if it.Account != w.account || decimal(it.Amount.Qty) != w.amount || it.Amount.Commod != w.commod {
+			t.Errorf("item %v: got %v %v, want %v %v %v", i, it.Account, it.Amount, w.account, w.amount, w.commod)
+		}
+	}
+
+	for _, trans := range journal[1:] {
+		if err := trans.Balance(); err == nil {
+			t.Errorf("expected error balancing '%v'", trans.Descrip)
+		} else {
+			t.Log(err)
+		}
+	}
+}
+
+func amountEqual(a, b *Amount) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Commod == b.Commod && a.Qty.Cmp(b.Qty) == 0
+}
+
+func costEqual(a, b *Cost) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Total == b.Total && amountEqual(&a.Amount, &b.Amount)
+}
+
+const costs = `
+2024/01/05 Buy
+    Assets:Brokerage    10 AAPL @ $150
+    Assets:Checking
+
+2024/02/01 Sell
+    Assets:Brokerage    -4 AAPL @@ $720
+    Assets:Checking     $720
+
+2024/03/01 Exchange
+    Assets:Euro         EUR 100 @ $1.10
+    Assets:Checking     $-120
+    Expenses:Fees
+
+2024/03/02 Unbalanced
+    Assets:Brokerage    3 AAPL @@ $500
+    Assets:Checking     $-450
+`
+
+func TestBalanceCosts(t *testing.T) {
+	journal, err := Parse("costs", strings.NewReader(costs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"$-1500.00", "", "$10.00"}
+	for i, w := range want {
+		trans := journal[i]
+		if err := trans.Balance(); err != nil {
+			t.Errorf("%v: %v", trans.Descrip, err)
+			continue
+		}
+		if last := trans.Items[len(trans.Items)-1]; w != "" && last.Amount.String() != w {
+			t.Errorf("%v: elided amount is %v, want %v", trans.Descrip, last.Amount, w)
+		}
+	}
+	if err := journal[3].Balance(); err == nil {
+		t.Errorf("expected error balancing '%v'", journal[3].Descrip)
+	}
+
+	sell := journal[1].Items[0]
+	if !sell.Cost.Total || sell.Price().String() != "$180.00" || sell.TotalCost().String() != "$-720.00" {
+		t.Errorf("got cost %v, price %v, total %v", sell.Cost.Amount, sell.Price(), sell.TotalCost())
+	}
+	buy := journal[0].Items[0]
+	if buy.Cost.Total || buy.Price().String() != "$150.00" || buy.TotalCost().String() != "$1500.00" {
+		t.Errorf("got cost %v, price %v, total %v", buy.Cost.Amount, buy.Price(), buy.TotalCost())
+	}
+
+	_, err = Parse("elided", strings.NewReader("2024/01/05 Buy\n    A  @ $5\n    B\n"))
+	if err == nil {
+		t.Errorf("expected an error for a cost with no amount")
+	}
+}
+
+const assertions = `
+2024/01/01 Opening
+    Assets:Checking         $1,000.00 = $1,000.00
+    Equity
+
+2024/01/05 Groceries
+    Expenses:Food           $50
+    Assets:Checking         = $950.00
+
+2024/01/06 Coffee
+    Expenses:Food           $5.32
+    Assets:Checking         $-15.32 = $952.58
+    Assets:Checking:Sub     $10 = $10
+
+2024/01/07 Fuel
+    Expenses:Fuel           $20
+    Assets:Checking         = $1,000.00 = $950.00
+
+2024/01/08 Refund
+    Assets:Checking         $10 = $1,000.00
+    Income:Refunds
+`
+
+func TestCheckBalances(t *testing.T) {
+	journal, err := Parse("assertions", strings.NewReader(assertions))
+	if err == nil {
+		t.Fatal("expected an error for a second assertion on one item")
+	}
+	fixed := strings.Replace(assertions, "= $1,000.00 = $950.00", "= $914.68", 1)
+	journal, err = Parse("assertions", strings.NewReader(fixed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CheckBalances(journal)
+	errs, ok := err.(AssertionErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("got error %v, want two failed assertions", err)
+	}
+	t.Log(errs)
+	if e := errs[0]; e.Item.Line != 12 || e.Actual.String() != "$934.68" {
+		t.Errorf("got failure on line %v with balance %v", e.Item.Line, e.Actual)
+	}
+	if e := errs[1]; e.Error() != "assertions:20: balance of Assets:Checking is $924.68, not $1,000.00 (off by $-75.32)" {
+		t.Errorf("got error %v", e)
+	}
+
+	if it := journal[1].Items[1]; it.Amount.String() != "$-50.00" {
+		t.Errorf("got assigned amount %v, want $-50.00", it.Amount)
+	}
+	if it := journal[3].Items[1]; it.Amount.String() != "$-20.00" {
+		t.Errorf("got assigned amount %v, want $-20.00", it.Amount)
+	}
+
+	journal, err = Parse("assign", strings.NewReader("2024/01/01 Open\n    A  = $5\n    B\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := journal[0].Balance(); err == nil {
+		t.Errorf("expected an error balancing an assignment outside CheckBalances")
+	}
+}
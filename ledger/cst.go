@@ -0,0 +1,241 @@
+package ledger
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// NodeKind identifies what a top-level chunk of a journal file holds.
+type NodeKind int
+
+const (
+	NodeTrans     NodeKind = iota // a transaction and its items
+	NodeComment                   // comment lines
+	NodeDirective                 // a directive and any indented lines under it
+	NodeBlank                     // a run of whitespace-only lines
+	NodeOther                     // indented text not belonging to anything else
+)
+
+var nodeNames = map[NodeKind]string{
+	NodeTrans:     "Trans",
+	NodeComment:   "Comment",
+	NodeDirective: "Directive",
+	NodeBlank:     "Blank",
+	NodeOther:     "Other",
+}
+
+func (k NodeKind) String() string { return nodeNames[k] }
+
+// commentChars start top-level comment lines.
+const commentChars = ";#%|*"
+
+// Node is a top-level chunk of a journal file.  Text holds the chunk's exact
+// source including its final line ending.
+type Node struct {
+	Kind  NodeKind
+	Pos   int    // byte offset of the node in the original file
+	Text  string // source text written back out
+	Trans *Trans // the parsed transaction for NodeTrans nodes
+	eol   string // line ending used by the node's file
+}
+
+// SetTrans replaces the node's transaction with t and regenerates its text.
+// The rest of the file is left untouched.
+func (n *Node) SetTrans(t *Trans, opts *FormatOpts) error {
+	var buf bytes.Buffer
+	if err := Format(&buf, []*Trans{t}, opts); err != nil {
+		return err
+	}
+	n.Kind = NodeTrans
+	n.Trans = t
+	n.Text = buf.String()
+	if n.eol == "\r\n" {
+		n.Text = strings.ReplaceAll(n.Text, "\n", n.eol)
+	}
+	return nil
+}
+
+// lineEnding returns the line ending of src's first line: "\r\n" or "\n".
+func lineEnding(src string) string {
+	if i := strings.IndexByte(src, '\n'); i > 0 && src[i-1] == '\r' {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// File is a lossless representation of a journal file: concatenating the
+// text of its nodes reproduces the input byte for byte.
+type File struct {
+	Name  string
+	Nodes []*Node
+}
+
+// isYearDirective reports whether line starts with a year directive.
+func isYearDirective(line string) bool {
+	f := strings.Fields(line)
+	return len(f) > 0 && (f[0] == "year" || f[0] == "Y")
+}
+
+// ParseFile reads a journal from r, keeping comments, blank lines and
+// layout alongside the parsed transactions.  Errors are as for Parse.
+func ParseFile(name string, r io.Reader) (*File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	src := string(data)
+	f := &File{Name: name, Nodes: split(src)}
+
+	// Only transactions and the year directives needed to read their dates
+	// are parsed.  Everything else is blanked out so offsets, and therefore
+	// error positions, still match the file.  Other directives, like alias,
+	// would change the transactions as they are written.
+	masked := []byte(src)
+	for _, n := range f.Nodes {
+		if n.Kind == NodeTrans || n.Kind == NodeDirective && isYearDirective(n.Text) {
+			continue
+		}
+		for i := n.Pos; i < n.Pos+len(n.Text); i++ {
+			if !isNewline(rune(masked[i])) {
+				masked[i] = ' '
+			}
+		}
+	}
+
+	pp := &Parser{}
+	if err := pp.parse(name, string(masked), false); err != nil {
+		return nil, err
+	}
+
+	byPos := map[int]*Trans{}
+	for _, t := range pp.Journal {
+		byPos[t.pos] = t
+	}
+	for _, n := range f.Nodes {
+		if n.Kind == NodeTrans {
+			n.Trans = byPos[n.Pos]
+		}
+	}
+	return f, nil
+}
+
+// Format rewrites the text of every transaction node in canonical form.
+// Comments, directives and blank lines are left untouched.  With
+// opts.FileWide, amounts are aligned across all of the file's transactions.
+func (f *File) Format(opts *FormatOpts) error {
+	o := opts
+	if opts != nil && opts.FileWide {
+		cols := measure(f.Journal()...)
+		cp := *opts
+		cp.FileWide = false
+		cp.cols = &cols
+		o = &cp
+	}
+	for _, n := range f.Nodes {
+		if n.Kind != NodeTrans || n.Trans == nil {
+			continue
+		}
+		if err := n.SetTrans(n.Trans, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SortByDate reorders the file's transactions by date.  Transactions move
+// between the existing transaction nodes so everything else stays put.  A
+// transaction missing its final line ending gets one.
+func (f *File) SortByDate() {
+	var nodes []*Node
+	for _, n := range f.Nodes {
+		if n.Kind == NodeTrans && n.Trans != nil {
+			nodes = append(nodes, n)
+		}
+	}
+	sorted := append([]*Node{}, nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Trans.Date.Before(sorted[j].Trans.Date)
+	})
+
+	moved := make([]Node, len(sorted))
+	for i, n := range sorted {
+		moved[i] = *n
+	}
+	for i, n := range nodes {
+		n.Text, n.Trans = moved[i].Text, moved[i].Trans
+		if n.Text != "" && !strings.HasSuffix(n.Text, "\n") {
+			n.Text += n.eol
+		}
+	}
+}
+
+// Journal returns the file's transactions in order.
+func (f *File) Journal() []*Trans {
+	var journal []*Trans
+	for _, n := range f.Nodes {
+		if n.Kind == NodeTrans && n.Trans != nil {
+			journal = append(journal, n.Trans)
+		}
+	}
+	return journal
+}
+
+// WriteTo writes the text of every node to w.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, n := range f.Nodes {
+		n, err := io.WriteString(w, n.Text)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// split divides src into top-level nodes line by line.  Indented non-blank
+// lines continue the node before them unless that is a blank run.
+func split(src string) []*Node {
+	var nodes []*Node
+	eol := lineEnding(src)
+	for pos := 0; pos < len(src); {
+		end := strings.IndexByte(src[pos:], '\n') + 1
+		if end == 0 {
+			end = len(src) - pos
+		}
+		line := src[pos : pos+end]
+
+		var kind NodeKind
+		switch r := rune(line[0]); {
+		case strings.TrimLeft(line, whitespace) == "":
+			kind = NodeBlank
+		case isSpace(r):
+			kind = NodeOther
+		case strings.ContainsRune(commentChars, r):
+			kind = NodeComment
+		case strings.ContainsRune(digit, r):
+			kind = NodeTrans
+		default:
+			kind = NodeDirective
+		}
+
+		var last *Node
+		if len(nodes) > 0 {
+			last = nodes[len(nodes)-1]
+		}
+		switch {
+		case last != nil && kind == NodeOther && last.Kind != NodeBlank:
+			last.Text += line
+		case last != nil && kind == NodeBlank && last.Kind == NodeBlank:
+			last.Text += line
+		case last != nil && kind == NodeComment && last.Kind == NodeComment:
+			last.Text += line
+		default:
+			nodes = append(nodes, &Node{Kind: kind, Pos: pos, Text: line, eol: eol})
+		}
+		pos += end
+	}
+	return nodes
+}
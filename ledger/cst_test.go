@@ -0,0 +1,149 @@
+package ledger
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const journal4 = `; Personal journal
+# kept since 2010
+
+account Assets:Checking
+    note the main account
+
+10/06/01 * Grocery store   ; weekly
+	Expenses:Food:Groceries      $42.10
+	; mostly vegetables
+	Assets:Checking
+; trailing comment
+10/06/02 Diner
+    Expenses:Food:Dining     $12.50
+    Liabilities:Credit card
+   
+  stray indented text
+10/06/03 Last one, no newline
+    Expenses:Misc  $1
+    Assets:Cash`
+
+func TestParseFile(t *testing.T) {
+	f, err := ParseFile("journal4", strings.NewReader(journal4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := []NodeKind{NodeComment, NodeBlank, NodeDirective, NodeBlank, NodeTrans, NodeComment, NodeTrans, NodeBlank, NodeOther, NodeTrans}
+	if len(f.Nodes) != len(kinds) {
+		for _, n := range f.Nodes {
+			t.Logf("%v %q", n.Kind, n.Text)
+		}
+		t.Fatalf("got %v nodes, want %v", len(f.Nodes), len(kinds))
+	}
+	for i, n := range f.Nodes {
+		if n.Kind != kinds[i] {
+			t.Errorf("node %v: got kind %v, want %v", i, n.Kind, kinds[i])
+		}
+	}
+
+	journal := f.Journal()
+	if len(journal) != 3 {
+		t.Fatalf("got %v transactions, want 3", len(journal))
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != journal4 {
+		t.Errorf("round trip changed the file:\n%v", buf.String())
+	}
+
+	// rewrite the diner transaction only
+	diner := f.Nodes[6]
+	diner.Trans.Items[0].Amount.Qty = big.NewRat(15, 1)
+	if err := diner.SetTrans(diner.Trans, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	f.WriteTo(&buf)
+	want := strings.Replace(journal4, `10/06/02 Diner
+    Expenses:Food:Dining     $12.50
+    Liabilities:Credit card
+`, `2010/06/02 Diner
+    Expenses:Food:Dining     $15.00
+    Liabilities:Credit card
+`, 1)
+	if buf.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", buf.String(), want)
+	}
+}
+
+func TestParseFileError(t *testing.T) {
+	_, err := ParseFile("bad", strings.NewReader("; comment\n\n10/06/01 Payee\n    A  $1 @@@ 3\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "bad:4:") {
+		t.Errorf("expected error on line 4, got %v", err)
+	}
+}
+
+func TestFileFormatSort(t *testing.T) {
+	const input = `; header
+
+10/06/03 Fill up
+  Expenses:Auto:Fuel     10 GAL
+      Assets:Fuel tank
+10/06/01 * Grocery store
+	Expenses:Food:Groceries   $42
+	Assets:Checking`
+
+	f, err := ParseFile("input", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SortByDate()
+	if err := f.Format(&FormatOpts{FileWide: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+	want := `; header
+
+2010/06/01 * Grocery store
+    Expenses:Food:Groceries     $42
+    Assets:Checking
+2010/06/03 Fill up
+    Expenses:Auto:Fuel       10 GAL
+    Assets:Fuel tank
+`
+	if buf.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", buf.String(), want)
+	}
+}
+
+func TestFileFormatCRLF(t *testing.T) {
+	input := strings.ReplaceAll("; header\n\n10/06/01 Grocery store\n  Expenses:Food  $42\n  Assets:Checking\n\n10/06/02 Diner\n  Expenses:Food  $12\n  Assets:Checking", "\n", "\r\n")
+	f, err := ParseFile("crlf", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Format(nil); err != nil {
+		t.Fatal(err)
+	}
+	f.SortByDate()
+
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+	want := strings.ReplaceAll(`; header
+
+2010/06/01 Grocery store
+    Expenses:Food    $42
+    Assets:Checking
+
+2010/06/02 Diner
+    Expenses:Food    $12
+    Assets:Checking
+`, "\n", "\r\n")
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
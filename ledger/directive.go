@@ -0,0 +1,323 @@
+package ledger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goledger/lex"
+	"github.com/rwcarlsen/goledger/parse"
+)
+
+// Directive is a top-level journal line other than a transaction or a
+// comment, like "account Assets:Checking" or "P 2024/03/01 AAPL $172.50",
+// along with any indented lines following it.
+type Directive struct {
+	Keyword string   // e.g. "account", "P" or "~"
+	Arg     string   // the rest of the first line
+	Lines   []string // indented lines, e.g. an account's "note ..."
+	File    string
+	Line    int
+	// Value is the directive's typed value, like an *AccountDirective,
+	// as returned by the DirectiveFunc for its keyword.
+	Value interface{}
+}
+
+// DirectiveFunc interprets a directive, returning its typed value.  It may
+// also change the state of the parser, as year directives do.
+type DirectiveFunc func(a *Parser, d *Directive) (interface{}, error)
+
+// RegisterDirective has the parser handle directives starting with keyword
+// using fn, replacing any built-in handling of the keyword.
+func (a *Parser) RegisterDirective(keyword string, fn DirectiveFunc) {
+	if a.handlers == nil {
+		a.handlers = map[string]DirectiveFunc{}
+	}
+	a.handlers[keyword] = fn
+}
+
+// The typed values of the built-in directives.
+type (
+	// AccountDirective declares an account.
+	AccountDirective struct{ Name string }
+	// CommodityDirective declares a commodity.
+	CommodityDirective struct{ Name string }
+	// PayeeDirective declares a payee.
+	PayeeDirective struct{ Name string }
+	// TagDirective declares a tag.
+	TagDirective struct{ Name string }
+	// AliasDirective makes Name, as an account or the first part of one,
+	// stand for Account in the items that follow.
+	AliasDirective struct{ Name, Account string }
+	// ApplyAccountDirective prefixes the accounts of the items that follow
+	// with Account until the matching end directive.
+	ApplyAccountDirective struct{ Account string }
+	// EndDirective ends the latest apply account directive.
+	EndDirective struct{}
+	// YearDirective sets the year of dates written without one.
+	YearDirective struct{ Year int }
+	// BucketDirective names the account that balances transactions written
+	// with a single item.
+	BucketDirective struct{ Account string }
+	// PriceDirective gives the market price of a commodity on a date.
+	PriceDirective struct {
+		Date   time.Time
+		Commod string
+		Price  Amount
+	}
+	// DefaultCommodityDirective sets the commodity, and its style, of
+	// amounts written without one.
+	DefaultCommodityDirective struct{ Amount Amount }
+	// NoMarketDirective marks a commodity as having no market price.
+	NoMarketDirective struct{ Commod string }
+	// IncludeDirective includes the files matching Pattern.
+	IncludeDirective struct{ Pattern string }
+	// PeriodicTrans is a transaction recurring every Period, e.g.
+	// "monthly", used for budgeting.
+	PeriodicTrans struct {
+		Period string
+		Items  []*Item
+		Notes  []string
+	}
+	// AutoTrans is an automated transaction whose items are added to the
+	// transactions matching Query.  The parser only records it.
+	AutoTrans struct {
+		Query string
+		Items []*Item
+		Notes []string
+	}
+)
+
+var builtinDirectives map[string]DirectiveFunc
+
+func init() {
+	builtinDirectives = map[string]DirectiveFunc{
+		"account":   func(a *Parser, d *Directive) (interface{}, error) { return &AccountDirective{d.Arg}, nil },
+		"commodity": func(a *Parser, d *Directive) (interface{}, error) { return &CommodityDirective{unquote(d.Arg)}, nil },
+		"payee":     func(a *Parser, d *Directive) (interface{}, error) { return &PayeeDirective{d.Arg}, nil },
+		"tag":       func(a *Parser, d *Directive) (interface{}, error) { return &TagDirective{d.Arg}, nil },
+		"alias":     (*Parser).alias,
+		"apply":     (*Parser).apply,
+		"end":       (*Parser).end,
+		"year":      (*Parser).year,
+		"Y":         (*Parser).year,
+		"bucket":    (*Parser).bucket,
+		"P":         (*Parser).price,
+		"D":         (*Parser).defaultCommodity,
+		"N":         func(a *Parser, d *Directive) (interface{}, error) { return &NoMarketDirective{unquote(d.Arg)}, nil },
+		"include":   func(a *Parser, d *Directive) (interface{}, error) { return &IncludeDirective{d.Arg}, nil },
+		"~":         func(a *Parser, d *Directive) (interface{}, error) { return &PeriodicTrans{Period: d.Arg}, nil },
+		"=":         func(a *Parser, d *Directive) (interface{}, error) { return &AutoTrans{Query: d.Arg}, nil },
+	}
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func (a *Parser) alias(d *Directive) (interface{}, error) {
+	i := strings.Index(d.Arg, "=")
+	if i < 0 {
+		return nil, fmt.Errorf("alias must be written NAME=ACCOUNT")
+	}
+	alias := &AliasDirective{strings.TrimSpace(d.Arg[:i]), strings.TrimSpace(d.Arg[i+1:])}
+	if a.aliases == nil {
+		a.aliases = map[string]string{}
+	}
+	a.aliases[alias.Name] = alias.Account
+	return alias, nil
+}
+
+func (a *Parser) apply(d *Directive) (interface{}, error) {
+	f := strings.Fields(d.Arg)
+	if len(f) < 2 || f[0] != "account" {
+		return nil, fmt.Errorf("unsupported directive 'apply %v'", d.Arg)
+	}
+	acct := strings.TrimSpace(strings.TrimPrefix(d.Arg, "account"))
+	a.applied = append(a.applied, acct)
+	return &ApplyAccountDirective{acct}, nil
+}
+
+func (a *Parser) end(d *Directive) (interface{}, error) {
+	switch strings.Join(strings.Fields(d.Arg), " ") {
+	case "", "apply", "apply account":
+	default:
+		return nil, fmt.Errorf("unsupported directive 'end %v'", d.Arg)
+	}
+	if len(a.applied) == 0 {
+		return nil, fmt.Errorf("end without apply account")
+	}
+	a.applied = a.applied[:len(a.applied)-1]
+	return &EndDirective{}, nil
+}
+
+func (a *Parser) year(d *Directive) (interface{}, error) {
+	year, err := strconv.Atoi(d.Arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid year '%v'", d.Arg)
+	}
+	a.Year = year
+	return &YearDirective{year}, nil
+}
+
+func (a *Parser) bucket(d *Directive) (interface{}, error) {
+	a.bucketAccount = a.account(d.Arg)
+	return &BucketDirective{a.bucketAccount}, nil
+}
+
+// price parses "P DATE [TIME] COMMODITY PRICE".
+func (a *Parser) price(d *Directive) (interface{}, error) {
+	f := strings.Fields(d.Arg)
+	if len(f) < 3 {
+		return nil, fmt.Errorf("price must be written P DATE COMMODITY PRICE")
+	}
+	date, err := a.parseDate(f[0], a.Year)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date '%v'", f[0])
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(d.Arg, f[0]))
+	if strings.Contains(f[1], ":") {
+		t, err := time.Parse("15:04:05", f[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time '%v'", f[1])
+		}
+		date = time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, f[1]))
+	}
+
+	commod := strings.Fields(rest)[0]
+	if strings.HasPrefix(rest, `"`) {
+		if i := strings.Index(rest[1:], `"`); i >= 0 {
+			commod = rest[:i+2]
+		}
+	}
+	price, err := a.ParseAmount(strings.TrimPrefix(rest, commod))
+	if err != nil {
+		return nil, err
+	}
+	return &PriceDirective{Date: date, Commod: unquote(commod), Price: *price}, nil
+}
+
+func (a *Parser) defaultCommodity(d *Directive) (interface{}, error) {
+	amt, err := a.ParseAmount(d.Arg)
+	if err != nil {
+		return nil, err
+	}
+	a.defaultCommod = amt.Commod
+	return &DefaultCommodityDirective{*amt}, nil
+}
+
+// ParseAmount parses s as an amount, such as "$1,204.11" or "10 AAPL",
+// learning its commodity's style as amounts in transactions do.
+func (a *Parser) ParseAmount(s string) (*Amount, error) {
+	var amt *Amount
+	var err error
+	p := parse.New(lex.New("amount", s, lexAmount), func(p *parse.Parser) parse.StateFn {
+		var ok bool
+		if amt, ok = a.amount(p); ok && amt == nil {
+			err = fmt.Errorf("missing amount")
+		} else if ok && p.Peek().Type != lex.TokEOF {
+			err = fmt.Errorf("unexpected text after amount '%v'", strings.TrimSpace(s))
+		}
+		return nil
+	})
+	if perr := p.Run(); perr != nil {
+		return nil, fmt.Errorf("invalid amount '%v': %v", strings.TrimSpace(s), perr.(*parse.Error).Msg)
+	}
+	return amt, err
+}
+
+// account returns the full name of an account as written in an item or
+// directive, after applying aliases and any apply account prefixes.
+func (a *Parser) account(name string) string {
+	first := name
+	if i := strings.Index(name, AccountSep); i >= 0 {
+		first = name[:i]
+	}
+	if acct, ok := a.aliases[name]; ok {
+		name = acct
+	} else if acct, ok := a.aliases[first]; ok {
+		name = acct + name[len(first):]
+	}
+	for i := len(a.applied) - 1; i >= 0; i-- {
+		name = a.applied[i] + AccountSep + name
+	}
+	return name
+}
+
+// pDirective parses a directive, including the items of a periodic or
+// automated transaction, and hands it to the function for its keyword.
+func (a *Parser) pDirective(p *parse.Parser) parse.StateFn {
+	a.subLines = nil
+	kw := p.Next()
+	line, _ := p.Position(kw)
+	d := &Directive{Keyword: kw.Val, File: p.Name(), Line: line}
+	at := kw
+	if tok := p.Peek(); tok.Type == tokArg {
+		p.Next()
+		d.Arg = strings.TrimSpace(tok.Val)
+		at = tok
+	}
+
+	fn, ok := a.handlers[d.Keyword]
+	if !ok {
+		fn, ok = builtinDirectives[d.Keyword]
+	}
+	if !ok {
+		return p.Errorf(kw, "unknown directive '%v'", d.Keyword)
+	}
+
+	end := func(p *parse.Parser) parse.StateFn {
+		v, err := fn(a, d)
+		if err != nil {
+			return p.Errorf(at, "%v", err)
+		}
+		d.Value = v
+		d.Lines, a.subLines = a.subLines, nil
+		switch v := v.(type) {
+		case *PeriodicTrans:
+			v.Items, v.Notes = a.currTrans.Items, a.currTrans.Notes
+		case *AutoTrans:
+			v.Items, v.Notes = a.currTrans.Items, a.currTrans.Notes
+		}
+		a.currTrans, a.currItem = nil, nil
+		a.Directives = append(a.Directives, d)
+
+		if v, ok := v.(*IncludeDirective); ok {
+			p.Push(a.Start)
+			return a.include(p, at, v.Pattern)
+		}
+		return a.Start
+	}
+
+	if d.Keyword == "~" || d.Keyword == "=" {
+		a.currTrans = &Trans{File: d.File, Line: d.Line, pos: kw.Pos}
+		a.currItem = nil
+		p.Push(end)
+		p.Push(a.pItems)
+		p.Push(a.pLineNote)
+		return a.pNote
+	}
+	p.Push(end)
+	p.Push(a.pSubLines)
+	return a.pNote
+}
+
+// pSubLines collects the indented lines following a directive.
+func (a *Parser) pSubLines(p *parse.Parser) parse.StateFn {
+	switch tok := p.Peek(); tok.Type {
+	case tokSubLine:
+		p.Next()
+		a.subLines = append(a.subLines, strings.TrimSpace(tok.Val))
+		p.Push(a.pSubLines)
+		return a.pNote
+	case tokMeta:
+		p.Push(a.pSubLines)
+		return a.pNote
+	}
+	return nil
+}
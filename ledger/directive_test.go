@@ -0,0 +1,170 @@
+package ledger
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const directives = `
+# accounts and commodities
+account Assets:Checking
+    note Main account
+    ; a comment
+commodity "S&P 500"
+payee Grocer
+tag trip
+N AAPL
+year 2023
+P 2023/03/01 AAPL $172.50
+P 03/02 12:30:00 "S&P 500" 4,100.25 USD
+D $1,000.00
+
+alias chk=Assets:Checking
+apply account Personal
+03/05 Aliased
+    Expenses:Food  12
+    chk
+end apply account
+
+bucket Assets:Cash
+03/06 Bucket
+    Expenses:Fuel  $30
+
+~ monthly  ; budget
+    Expenses:Food  $500
+    Assets
+
+= expr account =~ /Food/
+    (Budget:Food)  -1
+`
+
+func TestDirectives(t *testing.T) {
+	pp := &Parser{}
+	if err := pp.Parse("directives", strings.NewReader(directives), false); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range pp.Directives {
+		got = append(got, fmt.Sprintf("%v %v %T %v", d.Line, d.Keyword, d.Value, d.Lines))
+	}
+	want := []string{
+		"3 account *ledger.AccountDirective [note Main account]",
+		"6 commodity *ledger.CommodityDirective []",
+		"7 payee *ledger.PayeeDirective []",
+		"8 tag *ledger.TagDirective []",
+		"9 N *ledger.NoMarketDirective []",
+		"10 year *ledger.YearDirective []",
+		"11 P *ledger.PriceDirective []",
+		"12 P *ledger.PriceDirective []",
+		"13 D *ledger.DefaultCommodityDirective []",
+		"15 alias *ledger.AliasDirective []",
+		"16 apply *ledger.ApplyAccountDirective []",
+		"20 end *ledger.EndDirective []",
+		"22 bucket *ledger.BucketDirective []",
+		"26 ~ *ledger.PeriodicTrans []",
+		"30 = *ledger.AutoTrans []",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if v := pp.Directives[1].Value.(*CommodityDirective); v.Name != "S&P 500" {
+		t.Errorf("got commodity %q", v.Name)
+	}
+	if v := pp.Directives[6].Value.(*PriceDirective); v.Commod != "AAPL" || v.Price.String() != "$172.50" || v.Date.Format(dateFmt) != "2023/03/01" {
+		t.Errorf("got price %v %v on %v", v.Commod, v.Price, v.Date)
+	}
+	if v := pp.Directives[7].Value.(*PriceDirective); v.Commod != "S&P 500" || v.Price.String() != "4,100.25 USD" || v.Date.Hour() != 12 {
+		t.Errorf("got price %v %v on %v", v.Commod, v.Price, v.Date)
+	}
+	if v := pp.Directives[13].Value.(*PeriodicTrans); v.Period != "monthly" || len(v.Items) != 2 || v.Notes[0] != "budget" {
+		t.Errorf("got periodic transaction %+v", v)
+	}
+	if v := pp.Directives[14].Value.(*AutoTrans); v.Query != "expr account =~ /Food/" || len(v.Items) != 1 {
+		t.Errorf("got automated transaction %+v", v)
+	}
+
+	if len(pp.Journal) != 2 {
+		t.Fatalf("got %v transactions, want 2", len(pp.Journal))
+	}
+	aliased := pp.Journal[0]
+	if aliased.Date.Format(dateFmt) != "2023/03/05" {
+		t.Errorf("got date %v", aliased.Date.Format(dateFmt))
+	}
+	if it := aliased.Items[0]; it.Account != "Personal:Expenses:Food" || it.Amount.String() != "$12.00" {
+		t.Errorf("got item %v %v", it.Account, it.Amount)
+	}
+	if it := aliased.Items[1]; it.Account != "Personal:Assets:Checking" {
+		t.Errorf("got aliased account %v", it.Account)
+	}
+	bucket := pp.Journal[1]
+	if len(bucket.Items) != 2 || bucket.Items[0].Account != "Expenses:Fuel" || bucket.Items[1].Account != "Assets:Cash" {
+		t.Errorf("got items %+v", bucket.Items)
+	}
+}
+
+func TestRegisterDirective(t *testing.T) {
+	type budget struct{ Account, Limit string }
+
+	pp := &Parser{}
+	pp.RegisterDirective("budget", func(a *Parser, d *Directive) (interface{}, error) {
+		f := strings.Fields(d.Arg)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("budget must be written budget ACCOUNT LIMIT")
+		}
+		return &budget{f[0], f[1]}, nil
+	})
+	err := pp.Parse("budget", strings.NewReader("budget Expenses:Food 500\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := pp.Directives[0].Value.(*budget); !ok || v.Account != "Expenses:Food" || v.Limit != "500" {
+		t.Errorf("got value %+v", pp.Directives[0].Value)
+	}
+
+	err = pp.Parse("budget", strings.NewReader("budget Expenses:Food\n"), false)
+	if err == nil || !strings.Contains(err.Error(), "budget must be written") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+var directiveErrTests = []string{
+	"unknown thing\n",
+	"year twenty\n",
+	"end\n",
+	"apply tag trip\n",
+	"alias chk\n",
+	"P abc AAPL $1\n",
+	"P 2024/02/30 AAPL $1\n",
+	"P 2024/01/01 25:00:00 AAPL $1\n",
+	"P 2024/01/01 AAPL\n",
+	"P 2024/01/01 AAPL $1 $2\n",
+	"D\n",
+}
+
+func TestDirectiveErrors(t *testing.T) {
+	for _, input := range directiveErrTests {
+		_, err := Parse("bad", strings.NewReader(input))
+		if err == nil {
+			t.Errorf("expected an error parsing %q", input)
+		} else {
+			t.Log(err)
+		}
+	}
+}
+
+func TestDirectiveRecover(t *testing.T) {
+	pp := &Parser{}
+	input := "year abc\n    stale sub line\naccount Assets\n    note real\n"
+	if err := pp.Parse("recover", strings.NewReader(input), true); err == nil {
+		t.Fatal("expected an error for the year directive")
+	}
+	if len(pp.Directives) != 1 {
+		t.Fatalf("got %v directives, want 1", len(pp.Directives))
+	}
+	if d := pp.Directives[0]; d.Keyword != "account" || fmt.Sprint(d.Lines) != "[note real]" {
+		t.Errorf("got %v directive with lines %q", d.Keyword, d.Lines)
+	}
+}
@@ -0,0 +1,22 @@
+package ledger
+
+// Filter returns copies of the transactions in journal holding only the
+// items for which keep returns true.  Transactions left with no items are
+// dropped.
+func Filter(journal []*Trans, keep func(t *Trans, it *Item) bool) []*Trans {
+	var filtered []*Trans
+	for _, t := range journal {
+		var items []*Item
+		for _, it := range t.Items {
+			if keep(t, it) {
+				items = append(items, it)
+			}
+		}
+		if len(items) > 0 {
+			cp := *t
+			cp.Items = items
+			filtered = append(filtered, &cp)
+		}
+	}
+	return filtered
+}
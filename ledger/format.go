@@ -0,0 +1,155 @@
+package ledger
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatOpts controls the layout of journals written by Format.
+type FormatOpts struct {
+	// DateFmt is the layout used for transaction dates.  It defaults to
+	// YYYY/MM/DD.
+	DateFmt string
+	// Indent is written before each item.  It defaults to Tabwidth
+	// spaces.
+	Indent string
+	// FileWide aligns amounts in a single column across the whole journal
+	// rather than separately for each transaction.
+	FileWide bool
+
+	cols *columns // fixed alignment for all transactions
+}
+
+// Format writes journal to w in ledger syntax.  Amounts are right-aligned in
+// a column after the longest account name.  Parsing the output yields the
+// same transactions.
+func Format(w io.Writer, journal []*Trans, opts *FormatOpts) error {
+	o := FormatOpts{DateFmt: dateFmt, Indent: strings.Repeat(" ", Tabwidth)}
+	if opts != nil {
+		o.FileWide = opts.FileWide
+		o.cols = opts.cols
+		if opts.DateFmt != "" {
+			o.DateFmt = opts.DateFmt
+		}
+		if opts.Indent != "" {
+			o.Indent = opts.Indent
+		}
+	}
+
+	var cols columns
+	if o.cols != nil {
+		cols = *o.cols
+	} else if o.FileWide {
+		cols = measure(journal...)
+	}
+	for i, t := range journal {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		c := cols
+		if !o.FileWide && o.cols == nil {
+			c = measure(t)
+		}
+		if err := formatTrans(w, t, &o, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columns holds the widths needed to align the items of some transactions.
+type columns struct {
+	account int // width of status and account
+	amount  int
+}
+
+func measure(journal ...*Trans) columns {
+	var c columns
+	for _, t := range journal {
+		for _, it := range t.Items {
+			c.account = maxWidth(c.account, itemAccount(it))
+			if it.Amount != nil {
+				c.amount = maxWidth(c.amount, it.Amount.String())
+			}
+		}
+	}
+	return c
+}
+
+func itemAccount(it *Item) string {
+	if it.Status != "" {
+		return it.Status + " " + it.Account
+	}
+	return it.Account
+}
+
+func formatTrans(w io.Writer, t *Trans, o *FormatOpts, c columns) error {
+	line := t.Date.Format(o.DateFmt)
+	if !t.AuxDate.IsZero() {
+		line += "=" + t.AuxDate.Format(o.DateFmt)
+	}
+	if t.Status != "" {
+		line += " " + t.Status
+	}
+	if t.Code != "" {
+		line += " (" + t.Code + ")"
+	}
+	line += " " + t.Descrip
+	notes := t.Notes
+	if len(notes) > 0 && !t.lineNote {
+		line, notes = line+"  ; "+notes[0], notes[1:]
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+	if err := writeNotes(w, o.Indent, notes); err != nil {
+		return err
+	}
+
+	for _, it := range t.Items {
+		line := o.Indent + itemAccount(it)
+		if it.Amount != nil {
+			amt := it.Amount.String()
+			pad := c.account - utf8.RuneCountInString(itemAccount(it)) + c.amount - utf8.RuneCountInString(amt)
+			line += strings.Repeat(" ", pad+2) + amt
+		}
+		if it.Cost != nil && it.Cost.Total {
+			line += " @@ " + it.Cost.Amount.String()
+		} else if it.Cost != nil {
+			line += " @ " + it.Cost.Amount.String()
+		}
+		if it.Assert != nil && it.Amount == nil {
+			line += "  = " + it.Assert.String()
+		} else if it.Assert != nil {
+			line += " = " + it.Assert.String()
+		}
+		notes := it.Notes
+		if !it.AuxDate.IsZero() && !auxDateRe.MatchString(strings.Join(notes, "\n")) {
+			notes = append(notes[:len(notes):len(notes)], "[="+it.AuxDate.Format(o.DateFmt)+"]")
+		}
+		if len(notes) > 0 && !it.lineNote {
+			line, notes = line+"  ; "+notes[0], notes[1:]
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		if err := writeNotes(w, o.Indent, notes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNotes writes notes on lines of their own.
+func writeNotes(w io.Writer, indent string, notes []string) error {
+	for i := 0; i < len(notes); i++ {
+		if _, err := fmt.Fprintf(w, "%s; %s\n", indent, notes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package ledger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const journal3 = `
+10/06/01 * Grocery store  ; weekly shopping
+    Expenses:Food:Groceries   $42.10  ; mostly vegetables
+    ! Assets:Checking
+
+10/06/03 (42) Broker
+    Assets:Brokerage   10 AAPL @ $150
+    Assets:Checking
+`
+
+func TestFormat(t *testing.T) {
+	journal, err := Parse("journal3", strings.NewReader(journal3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Format(&buf, journal, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `2010/06/01 * Grocery store  ; weekly shopping
+    Expenses:Food:Groceries  $42.10  ; mostly vegetables
+    ! Assets:Checking
+
+2010/06/03 (42) Broker
+    Assets:Brokerage  10 AAPL @ $150.00
+    Assets:Checking
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+
+	again, err := Parse("formatted", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != len(journal) {
+		t.Fatalf("got %v transactions back, want %v", len(again), len(journal))
+	}
+	for i, t1 := range journal {
+		t2 := again[i]
+		if !t1.Date.Equal(t2.Date) || t1.Status != t2.Status || t1.Code != t2.Code || t1.Descrip != t2.Descrip || !notesEqual(t1.Notes, t2.Notes) {
+			t.Errorf("trans %v: got %+v, want %+v", i, t2, t1)
+		}
+		for j, it1 := range t1.Items {
+			it2 := t2.Items[j]
+			if it1.Account != it2.Account || it1.Status != it2.Status || !notesEqual(it1.Notes, it2.Notes) ||
+				!amountEqual(it1.Amount, it2.Amount) || !costEqual(it1.Cost, it2.Cost) {
+				t.Errorf("trans %v item %v: got %+v, want %+v", i, j, it2, it1)
+			}
+		}
+	}
+
+	buf.Reset()
+	if err := Format(&buf, journal, &FormatOpts{DateFmt: "06/01/02", Indent: "\t", FileWide: true}); err != nil {
+		t.Fatal(err)
+	}
+	want = `10/06/01 * Grocery store  ; weekly shopping
+	Expenses:Food:Groceries   $42.10  ; mostly vegetables
+	! Assets:Checking
+
+10/06/03 (42) Broker
+	Assets:Brokerage         10 AAPL @ $150.00
+	Assets:Checking
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func notesEqual(a, b []string) bool {
+	return strings.Join(a, "\n") == strings.Join(b, "\n") && len(a) == len(b)
+}
+
+// trans2 is the multi-line note fixture from ledger/old.
+const trans2 = `
+2009/05/14  * Gas Station
+	; blablabla a transaction comment
+    Assets:Westmark Checking                  $5.32
+    ; used a debit card to pay
+    ; twice
+    Expenses:Transportation:Gas  ; topped of tank
+    ; with premium
+`
+
+func TestFormatNotes(t *testing.T) {
+	journal, err := Parse("trans2", strings.NewReader(trans2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans := journal[0]
+	if got := strings.Join(trans.Notes, "|"); got != "blablabla a transaction comment" {
+		t.Errorf("got transaction notes %q", trans.Notes)
+	}
+	if got := strings.Join(trans.Items[0].Notes, "|"); got != "used a debit card to pay|twice" {
+		t.Errorf("got first item notes %q", trans.Items[0].Notes)
+	}
+	if got := strings.Join(trans.Items[1].Notes, "|"); got != "topped of tank|with premium" {
+		t.Errorf("got second item notes %q", trans.Items[1].Notes)
+	}
+
+	var buf bytes.Buffer
+	if err := Format(&buf, journal, nil); err != nil {
+		t.Fatal(err)
+	}
+	// notes stay inline or on lines of their own as written
+	want := `2009/05/14 * Gas Station
+    ; blablabla a transaction comment
+    Assets:Westmark Checking     $5.32
+    ; used a debit card to pay
+    ; twice
+    Expenses:Transportation:Gas  ; topped of tank
+    ; with premium
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+
+	journal, err = Parse("trans2", strings.NewReader("2009/05/14 Gas Station  ; inline\n    ; own line\n    A  $5\n    B\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := Format(&buf, journal, nil); err != nil {
+		t.Fatal(err)
+	}
+	want = "2009/05/14 Gas Station  ; inline\n    ; own line\n    A  $5\n    B\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestFormatNumberRoundTrip(t *testing.T) {
+	const input = `2024/01/05 Euros
+    A  EUR 5,5
+    A  EUR 1,234
+    A  EUR 1.234.567,5
+    A  2.000 NOK
+    A  3.000.000 NOK
+    A  1,5 NOK
+    B
+`
+	journal, err := Parse("euros", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Format(&buf, journal, nil); err != nil {
+		t.Fatal(err)
+	}
+	formatted := buf.String()
+	again, err := Parse("formatted", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"5.5", "1.234", "1234567.5", "2000", "3000000", "1.5"}
+	for i, w := range want {
+		if got := decimal(again[0].Items[i].Amount.Qty); got != w || !amountEqual(journal[0].Items[i].Amount, again[0].Items[i].Amount) {
+			t.Errorf("item %v: got %v, want %v, from\n%v", i, got, w, formatted)
+		}
+	}
+	if strings.Contains(formatted, "EUR 5,500\n") || strings.Contains(formatted, "2.000 NOK") {
+		t.Errorf("formatted ambiguous numbers:\n%v", formatted)
+	}
+}
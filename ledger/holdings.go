@@ -0,0 +1,62 @@
+package ledger
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Holding is the amount of one lot of a commodity held in an account.
+type Holding struct {
+	Account *Account
+	Amount  Amount
+	// Basis is the lot's price times the amount held, or nil if the lot has
+	// no price.
+	Basis *Amount
+}
+
+// Holdings returns the non-zero amounts posted directly to each account, one
+// per lot, ordered by account name and then by commodity and lot date.
+func (a *Accounts) Holdings() []Holding {
+	var holdings []Holding
+	var walk func(acct *Account)
+	walk = func(acct *Account) {
+		for _, amt := range acct.Amounts.Amounts() {
+			h := Holding{Account: acct, Amount: amt}
+			if amt.Lot != nil && amt.Lot.Price != nil {
+				basis := amt.Lot.Price.Mul(amt.Qty)
+				h.Basis = &basis
+			}
+			holdings = append(holdings, h)
+		}
+		for _, child := range acct.Children {
+			walk(child)
+		}
+	}
+	walk(a.Root)
+	return holdings
+}
+
+// WriteHoldings writes holdings as columns of account, amount with its lot
+// annotations and cost basis.
+func WriteHoldings(w io.Writer, holdings []Holding) error {
+	amtWidth, basisWidth := 0, 0
+	for _, h := range holdings {
+		amtWidth = maxWidth(amtWidth, h.Amount.String())
+		if h.Basis != nil {
+			basisWidth = maxWidth(basisWidth, h.Basis.String())
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, Minwidth, Tabwidth, Padding, Padchar, 0)
+	for _, h := range holdings {
+		line := h.Account.FullName + "\t" + padLeft(h.Amount.String(), amtWidth)
+		if h.Basis != nil {
+			line += "\t" + padLeft(h.Basis.String(), basisWidth)
+		}
+		if _, err := fmt.Fprintln(tw, line); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
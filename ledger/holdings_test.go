@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const lots = `
+2023/05/01 Buy
+    Assets:Brokerage    10 AAPL {$150} [2023/05/01]
+    Assets:Checking
+
+2023/08/01 Buy
+    Assets:Brokerage    5 AAPL {$160.50} [2023/08/01] (dip)
+    Assets:Checking
+
+2024/02/01 Sell
+    Assets:Brokerage    -4 AAPL {$150} [2023/05/01] @ $180
+    Assets:Checking
+
+2024/02/02 Deposit
+    Assets:Checking     $100
+    Income:Salary
+`
+
+func TestHoldings(t *testing.T) {
+	journal := balanced(t, lots)
+
+	lot := journal[1].Items[0].Amount.Lot
+	if lot == nil || lot.Price.String() != "$160.50" || lot.Date.Format(dateFmt) != "2023/08/01" || lot.Note != "dip" {
+		t.Fatalf("got lot %v", lot)
+	}
+	if got := journal[0].Items[1].Amount.String(); got != "$-1500.00" {
+		t.Errorf("buy balanced against %v, want $-1500.00", got)
+	}
+	if got := journal[2].Items[1].Amount.String(); got != "$720.00" {
+		t.Errorf("sale balanced against %v, want $720.00", got)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHoldings(&buf, NewAccounts(journal).Holdings()); err != nil {
+		t.Fatal(err)
+	}
+	want := `
+Assets:Brokerage        6 AAPL {$150.00} [2023/05/01]  $900.00
+Assets:Brokerage  5 AAPL {$160.50} [2023/08/01] (dip)  $802.50
+Assets:Checking                             $-1482.50
+Income:Salary                                $-100.00
+`
+	if got := "\n" + buf.String(); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestLotErrors(t *testing.T) {
+	for _, bad := range []string{"10 AAPL [abc]", "10 AAPL [2023]", "10 AAPL [2023/02/30]", "10 AAPL {$150} [1/2/3/4]"} {
+		input := "2023/05/01 Buy\n    Assets:Brokerage  " + bad + "\n    Assets:Checking  $-1500\n"
+		if _, err := Parse("lots", strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), "invalid lot date") {
+			t.Errorf("%v: got error %v, want an invalid lot date", bad, err)
+		}
+	}
+}
+
+func TestBalanceLots(t *testing.T) {
+	journal := balanced(t, `
+2023/06/01 Transfer
+    Assets:Broker B    10 AAPL [2023/01/01]
+    Assets:Broker A   -10 AAPL
+
+2023/06/02 Transfer back
+    Assets:Broker A    10 AAPL [2023/01/01] (long)
+    Assets:Broker B
+`)
+	if got := journal[1].Items[1].Amount.String(); got != "-10 AAPL" {
+		t.Errorf("elided amount is %v, want -10 AAPL", got)
+	}
+}
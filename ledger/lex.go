@@ -1,6 +1,7 @@
 package ledger
 
 import (
+	"strings"
 	"unicode"
 
 	"github.com/rwcarlsen/goledger/lex"
@@ -21,6 +22,17 @@ const (
 	tokAt
 	tokAtAt
 	tokEndTrans
+	tokAuxDate
+	tokCode
+	tokSign
+	tokLBrace
+	tokRBrace
+	tokLotDate
+	tokLotNote
+	tokDirective
+	tokArg
+	tokSubLine
+	tokAssert
 )
 
 var tokNames = map[lex.TokType]string{
@@ -40,6 +52,17 @@ var tokNames = map[lex.TokType]string{
 	tokAmount:     "Amount",
 	tokAt:         "At",
 	tokAtAt:       "AtAt",
+	tokAuxDate:    "AuxDate",
+	tokCode:       "Code",
+	tokSign:       "Sign",
+	tokLBrace:     "LBrace",
+	tokRBrace:     "RBrace",
+	tokLotDate:    "LotDate",
+	tokLotNote:    "LotNote",
+	tokDirective:  "Directive",
+	tokArg:        "Arg",
+	tokSubLine:    "SubLine",
+	tokAssert:     "Assert",
 }
 
 /////////////////// state functions ///////////////////////
@@ -50,21 +73,32 @@ const (
 	whitespace = indent + lineend
 	digit      = "0123456789"
 	statuss    = "*!"
+	datesep    = "/-."
+	sign       = "-+"
+	numchars   = digit + ".,"
 )
 
 const (
-	meta = ";"
-	atat = "@@"
-	at   = "@"
+	meta   = ";"
+	atat   = "@@"
+	at     = "@"
+	assert = "="
 )
 
-// lexStart looks for a comment or a transaction, it emits everything
-// inbetween as tokText
+// commodStop holds the runes that end an unquoted commodity.  A commodity
+// containing any of them must be written in double quotes.
+const commodStop = whitespace + numchars + sign + meta + at + assert + `"(){}[]`
+
+// lexStart looks for a comment, a transaction or a directive at the start
+// of a top-level line.
 func lexStart(l *lex.Lexer) lex.StateFn {
 	switch r := l.Peek(); {
 	case string(r) == meta:
 		l.Push(lexStart)
 		return lexMeta
+	case strings.ContainsRune(commentChars, r):
+		l.Push(lexStart)
+		return lexComment
 	case unicode.IsDigit(r):
 		l.Push(lexStart)
 		return lexTrans
@@ -75,9 +109,8 @@ func lexStart(l *lex.Lexer) lex.StateFn {
 		l.Emit(lex.TokEOF)
 		return nil
 	default:
-		l.Errorf("unexpected text on line %v", l.LineNumber())
 		l.Push(lexStart)
-		return lexSkipLine
+		return lexDirective
 	}
 }
 
@@ -88,6 +121,7 @@ func lexTrans(l *lex.Lexer) lex.StateFn {
 	l.Push(lexItems)
 	l.Push(lexMeta)
 	l.Push(lexPayee)
+	l.Push(lexCode)
 	l.Push(lexStatus)
 	return lexDate
 }
@@ -97,30 +131,98 @@ func lexEndTrans(l *lex.Lexer) lex.StateFn {
 	return nil
 }
 
+// lexDate scans a date of two or three runs of digits separated by one of
+// the date separators, e.g. 2024/05/14, 24-05-14 or 05.14.  It may be
+// followed by '=' and an auxiliary date.
 func lexDate(l *lex.Lexer) lex.StateFn {
-	fail := false
-	if l.AcceptRun(digit) == 0 {
-		fail = true
-	} else if !l.Accept("/") {
-		fail = true
-	} else if l.AcceptRun(digit) == 0 {
-		fail = true
-	} else if !l.Accept("/") {
-		fail = true
-	} else if l.AcceptRun(digit) == 0 {
-		fail = true
+	fail := !acceptDate(l)
+	if !fail {
+		l.Emit(tokDate)
+		if l.Accept("=") {
+			l.Ignore()
+			if fail = !acceptDate(l); !fail {
+				l.Emit(tokAuxDate)
+			}
+		}
 	}
 
 	if fail {
 		l.AcceptRunNot(whitespace + meta)
-		l.Errorf("invalid date on line %v", l.LineNumber())
+		l.Errorf("invalid date")
 		l.Ignore()
-	} else {
-		l.Emit(tokDate)
 	}
 	return nil
 }
 
+func acceptDate(l *lex.Lexer) bool {
+	if l.AcceptRun(digit) == 0 {
+		return false
+	} else if sep := l.Peek(); !l.Accept(datesep) {
+		return false
+	} else if l.AcceptRun(digit) == 0 {
+		return false
+	} else if l.Accept(string(sep)) && l.AcceptRun(digit) == 0 {
+		return false
+	}
+	r := l.Peek()
+	return isSpace(r) || isNewline(r) || r == '=' || r == lex.EOF
+}
+
+// lexDirective scans a top-level directive: a keyword, the rest of its
+// line as an argument and any indented lines following it.  The indented
+// lines of periodic (~) and automated (=) transactions are postings; those
+// of other directives are emitted whole.
+func lexDirective(l *lex.Lexer) lex.StateFn {
+	if !l.Accept("~=") {
+		l.AcceptRunNot(whitespace + meta)
+	}
+	kw := l.Input[l.Start:l.Pos]
+	l.Emit(tokDirective)
+
+	l.AcceptRun(indent)
+	l.Ignore()
+	if l.AcceptRunNot(lineend+meta) > 0 {
+		l.Emit(tokArg)
+	}
+
+	if kw == "~" || kw == "=" {
+		l.Push(lexEndTrans)
+		l.Push(lexItems)
+	} else {
+		l.Push(lexSubLines)
+	}
+	return lexMeta
+}
+
+// lexSubLines scans the indented lines following a directive, such as the
+// "note" and "format" lines of account and commodity directives.
+func lexSubLines(l *lex.Lexer) lex.StateFn {
+	if l.AcceptRun(indent) == 0 {
+		return nil
+	} else if r := l.Peek(); isNewline(r) || r == lex.EOF {
+		l.Ignore()
+		return nil
+	}
+	l.Ignore()
+
+	l.Push(lexSubLines)
+	if string(l.Peek()) != meta {
+		l.AcceptRunNot(lineend + meta)
+		l.Emit(tokSubLine)
+	}
+	return lexMeta
+}
+
+// lexComment scans a top-level comment starting with one of the other
+// comment characters ledger allows, like '#'.
+func lexComment(l *lex.Lexer) lex.StateFn {
+	l.Next()
+	l.Emit(tokMeta)
+	l.AcceptRun(indent)
+	l.Ignore()
+	return lexText
+}
+
 func lexStatus(l *lex.Lexer) lex.StateFn {
 	l.AcceptRun(indent)
 	l.Ignore()
@@ -130,6 +232,26 @@ func lexStatus(l *lex.Lexer) lex.StateFn {
 	return nil
 }
 
+// lexCode scans an optional transaction code in parentheses, e.g. a check
+// number, emitting it without the parentheses.
+func lexCode(l *lex.Lexer) lex.StateFn {
+	l.AcceptRun(indent)
+	l.Ignore()
+	if !l.Accept("(") {
+		return nil
+	}
+	l.Ignore()
+
+	l.AcceptRunNot(")" + lineend)
+	if l.Peek() != ')' {
+		return l.Errorf("unterminated transaction code")
+	}
+	l.Emit(tokCode)
+	l.Next()
+	l.Ignore()
+	return nil
+}
+
 func lexPayee(l *lex.Lexer) lex.StateFn {
 	if l.AcceptRunNot(lineend+meta) > 0 {
 		l.Emit(tokPayee)
@@ -140,7 +262,11 @@ func lexPayee(l *lex.Lexer) lex.StateFn {
 func lexItems(l *lex.Lexer) lex.StateFn {
 	if l.AcceptRun(indent) == 0 {
 		return nil
-	} else if string(l.Peek()) == meta {
+	} else if r := l.Peek(); isNewline(r) || r == lex.EOF {
+		// a whitespace-only line ends the transaction
+		l.Ignore()
+		return nil
+	} else if string(r) == meta {
 		l.Push(lexItems)
 		return lexMeta
 	}
@@ -153,18 +279,22 @@ func lexItems(l *lex.Lexer) lex.StateFn {
 
 func lexItem(l *lex.Lexer) lex.StateFn {
 	l.Push(lexMeta)
+	l.Push(lexAssert)
 	l.Push(lexAmount)
 	l.Push(lexAt)
+	l.Push(lexLot)
 	l.Push(lexAmount)
 	l.Push(lexAccount)
 	return lexStatus
 }
 
 func lexAccount(l *lex.Lexer) lex.StateFn {
+	l.AcceptRun(indent)
+	l.Ignore()
 	for {
 		nr := l.Next()
 		nnr := l.Peek()
-		if isSpace(nr) && isSpace(nnr) || isNewline(nr) {
+		if isSpace(nr) && isSpace(nnr) || nr == '\t' || isNewline(nr) || nr == lex.EOF {
 			l.Backup()
 			l.Emit(tokAccount)
 			l.AcceptRun(indent)
@@ -174,35 +304,107 @@ func lexAccount(l *lex.Lexer) lex.StateFn {
 	}
 }
 
+// lexAmount scans an amount with an optional prefix commodity, e.g. 10,
+// $5.32, -$5.32, $-5.32, EUR 1.234,56 or "S&P 500" 3.  A sign written
+// before a prefix commodity is emitted on its own; otherwise it is part of
+// the number.  Which of '.' and ',' is the decimal mark is left to the
+// parser.
 func lexAmount(l *lex.Lexer) lex.StateFn {
 	l.AcceptRun(indent)
 	l.Ignore()
 
-	if l.Accept("$") {
+	if l.Accept(sign) && !unicode.IsDigit(l.Peek()) {
+		l.Emit(tokSign)
+	}
+	if acceptCommod(l) {
 		l.Emit(tokUnit)
+		l.AcceptRun(indent)
+		l.Ignore()
+		l.Accept(sign)
 	}
 
-	l.AcceptRun(digit + ",")
-	l.Accept(".")
-	l.AcceptRun(digit)
+	l.AcceptRun(numchars)
 	if l.Pos > l.Start {
 		l.Emit(tokAmount)
 	}
 	return lexCommod
 }
 
+// lexCommod scans an optional commodity written after the number.
 func lexCommod(l *lex.Lexer) lex.StateFn {
 	l.AcceptRun(indent)
 	l.Ignore()
-	if l.AcceptRunNot(whitespace+meta+at) > 0 {
+	if acceptCommod(l) {
 		l.Emit(tokCommod)
 	}
 	return nil
 }
 
+// acceptCommod accepts a commodity, either double quoted or a run of runes
+// outside commodStop.  The quotes are kept in the token; an unterminated
+// quote runs to the end of the line and is left for the parser to report.
+func acceptCommod(l *lex.Lexer) bool {
+	if !l.Accept(`"`) {
+		return l.AcceptRunNot(commodStop) > 0
+	}
+	l.AcceptRunNot(`"` + lineend)
+	l.Accept(`"`)
+	return true
+}
+
+// lexLot scans the lot annotations that may follow an item's amount, in any
+// order: a {PRICE} per unit, a [DATE] and a (NOTE).
+func lexLot(l *lex.Lexer) lex.StateFn {
+	l.AcceptRun(indent)
+	l.Ignore()
+	switch l.Peek() {
+	case '{':
+		l.Next()
+		l.Emit(tokLBrace)
+		l.Push(lexLot)
+		l.Push(lexRBrace)
+		return lexAmount
+	case '[':
+		return lexEnclosed(l, ']', tokLotDate, "lot date")
+	case '(':
+		return lexEnclosed(l, ')', tokLotNote, "lot note")
+	}
+	return nil
+}
+
+func lexRBrace(l *lex.Lexer) lex.StateFn {
+	l.AcceptRun(indent)
+	l.Ignore()
+	if !l.Accept("}") {
+		l.Errorf("missing '}' after lot price")
+		return lexSkipLine
+	}
+	l.Emit(tokRBrace)
+	return nil
+}
+
+// lexEnclosed emits the text between the opening rune at the current
+// position and end as a token of type typ, then looks for more lot
+// annotations.
+func lexEnclosed(l *lex.Lexer, end rune, typ lex.TokType, what string) lex.StateFn {
+	l.Next()
+	l.Ignore()
+	l.AcceptRunNot(string(end) + lineend)
+	if l.Peek() != end {
+		l.Errorf("unterminated %v", what)
+		return lexSkipLine
+	}
+	l.Emit(typ)
+	l.Next()
+	l.Ignore()
+	return lexLot
+}
+
 func lexAt(l *lex.Lexer) lex.StateFn {
+	l.AcceptRun(indent)
+	l.Ignore()
 	if n := l.AcceptRun(at); n > 2 {
-		l.Errorf("invalid token on line %v", l.LineNumber())
+		l.Errorf("invalid token")
 		return lexSkipLine
 	} else if n == 1 {
 		l.Emit(tokAt)
@@ -212,12 +414,26 @@ func lexAt(l *lex.Lexer) lex.StateFn {
 	return nil
 }
 
+// lexAssert scans the balance asserted after an item, as in
+// "Assets:Checking  $-5.32 = $1,204.11" or "Assets:Checking  = $1,204.11".
+func lexAssert(l *lex.Lexer) lex.StateFn {
+	l.AcceptRun(indent)
+	l.Ignore()
+	if l.Accept(assert) {
+		l.Emit(tokAssert)
+		return lexAmount
+	}
+	return nil
+}
+
 func lexBlankLine(l *lex.Lexer) lex.StateFn {
 	l.AcceptRun(indent)
 	l.Ignore()
 
-	if l.AcceptRun(lineend) == 0 {
-		l.Errorf("unexpected non-blank line at line %v", l.LineNumber())
+	if l.Peek() == lex.EOF {
+		return nil
+	} else if l.AcceptRun(lineend) == 0 {
+		l.Errorf("unexpected non-blank line")
 		return lexSkipLine
 	}
 	l.Ignore()
@@ -233,7 +449,10 @@ func lexSkipLine(l *lex.Lexer) lex.StateFn {
 
 func lexNewline(l *lex.Lexer) lex.StateFn {
 	l.AcceptRun(indent)
-	if l.AcceptRun(lineend) == 0 {
+	if l.Peek() == lex.EOF {
+		// a missing newline at the end of the file is fine
+		l.Emit(tokNewline)
+	} else if l.AcceptRun(lineend) == 0 {
 		l.Errorf("lexer error - missing expected newline")
 	} else {
 		l.Emit(tokNewline)
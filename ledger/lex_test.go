@@ -2,6 +2,7 @@ package ledger
 
 import (
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	"github.com/rwcarlsen/goledger/lex"
@@ -33,3 +34,28 @@ func BenchmarkHello(b *testing.B) {
 		}
 	}
 }
+
+var lexAccountTests = []struct {
+	input string
+	want  []string
+}{
+	{"2023/01/01 Tab\n    Expenses:Food\t€5.00\n    Assets\n", []string{"Expenses:Food", "Assets"}},
+	{"2023/01/01 Newline\n    A  $1\n    B\n€\n", []string{"A", "B"}},
+	{"2023/01/01 EOF\n    A  $1\n    B€", []string{"A", "B€"}},
+	{"1.1 0\n 0\n㷁\xea\x97\xda", []string{"0"}},
+}
+
+func TestLexAccount(t *testing.T) {
+	for _, test := range lexAccountTests {
+		var got []string
+		l := lex.New("test", test.input, lexStart)
+		for tok := range l.Tokens {
+			if tok.Type == tokAccount {
+				got = append(got, tok.Val)
+			}
+		}
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("lexing %q got accounts %q, want %q", test.input, got, test.want)
+		}
+	}
+}
@@ -2,190 +2,686 @@ package ledger
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
 	"math/big"
+	"path"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/rwcarlsen/goledger/lex"
 	"github.com/rwcarlsen/goledger/parse"
 )
 
+// dateFmt is the layout used when writing dates.
+const dateFmt = "2006/01/02"
+
 type Trans struct {
 	Date    time.Time
+	AuxDate time.Time // auxiliary (effective) date; zero if none
 	Status  string
+	Code    string // e.g. a check number
 	Descrip string
 	Items   []*Item
-	Note    string
+	Notes   []string // comments in the order written
+	// Tags holds the :tags: and "Key: value" metadata from the notes.
+	// Plain tags have empty values.
+	Tags map[string]string
+	// File and Line locate the transaction's first line in its input.
+	File     string
+	Line     int
+	pos      int  // byte offset of the transaction in its input
+	lineNote bool // the first note was on a line of its own
 }
 
 type Item struct {
-	Status   string
-	Account  string
-	Amount   *big.Rat
-	Commod   string
-	ExAmount *big.Rat
-	ExCommod string
-	Note     string
+	AuxDate time.Time // auxiliary (effective) date from a [=DATE] note
+	Status  string
+	Account string
+	Amount  *Amount // nil if elided
+	Cost    *Cost   // nil if no cost was given
+	// Assert is the balance the account must have in Assert's commodity
+	// after the item, or nil if none was asserted.  An item with an
+	// assertion but no amount is a balance assignment: CheckBalances
+	// gives it the amount that brings the account to the balance.
+	Assert *Amount
+	Notes  []string // comments in the order written
+	Line   int      // of the item in the file it was parsed from
+	// Tags holds the item's tags and metadata including those it inherits
+	// from its transaction.
+	Tags     map[string]string
+	lineNote bool // the first note was on a line of its own
 }
 
-type Parser struct {
-	Journal   []*Trans
-	currTrans *Trans
-	currItem  *Item
-	currNote  string
-	currAmt   *big.Rat
+// Cost is what an item's amount cost in another commodity, written either
+// per unit ("10 AAPL @ $150") or as a total ("10 AAPL @@ $1500").
+type Cost struct {
+	Amount Amount // as written
+	Total  bool   // written with @@
 }
 
-func (a *Parser) pNote(p *parse.Parser) parse.StateFn {
-	switch tok := p.Peek(); tok.Type {
-	case tokMeta:
-		p.Next()
-		tok = p.Next()
-		a.currNote = tok.Val
-		if tok = p.Next(); tok.Type != tokNewline {
-			panic(fmt.Sprintf("unexpected token %v: '%v'", tokNames[tok.Type], tok.Val))
+// Price returns the cost of one unit of the item's amount, or nil if the
+// item has no cost.  Without an @ or @@ cost, the price of the amount's lot
+// is used.
+func (it *Item) Price() *Amount {
+	if it.Cost == nil {
+		return it.lotPrice()
+	} else if it.Amount == nil {
+		return nil
+	}
+	price := it.Cost.Amount
+	if it.Cost.Total && !it.Amount.IsZero() {
+		qty := new(big.Rat).Abs(it.Amount.Qty)
+		price = price.Mul(qty.Inv(qty))
+	}
+	return &price
+}
+
+// TotalCost returns the cost of the item's whole amount, with the amount's
+// sign, or nil if the item has no cost.  Like Price, it falls back to the
+// price of the amount's lot.
+func (it *Item) TotalCost() *Amount {
+	if it.Cost == nil {
+		if price := it.lotPrice(); price != nil {
+			total := price.Mul(it.Amount.Qty)
+			return &total
 		}
 		return nil
-	default:
+	} else if it.Amount == nil {
+		return nil
+	}
+	if !it.Cost.Total {
+		total := it.Cost.Amount.Mul(it.Amount.Qty)
+		return &total
+	}
+	total := it.Cost.Amount.Mul(big.NewRat(int64(it.Amount.Sign()*it.Cost.Amount.Sign()), 1))
+	return &total
+}
+
+func (it *Item) lotPrice() *Amount {
+	if it.Amount == nil || it.Amount.Lot == nil {
 		return nil
 	}
+	return it.Amount.Lot.Price
+}
+
+// ItemDate returns the date of it, one of t's items.  With aux set, the
+// item's auxiliary date is used if it has one, then the transaction's.
+func (t *Trans) ItemDate(it *Item, aux bool) time.Time {
+	if aux && !it.AuxDate.IsZero() {
+		return it.AuxDate
+	} else if aux && !t.AuxDate.IsZero() {
+		return t.AuxDate
+	}
+	return t.Date
+}
+
+// Parse reads a ledger journal from r and returns its transactions.  name
+// identifies the input in errors, which are of type *parse.Error.
+func Parse(name string, r io.Reader) ([]*Trans, error) {
+	pp := &Parser{}
+	if err := pp.Parse(name, r, false); err != nil {
+		return nil, err
+	}
+	return pp.Journal, nil
+}
+
+// ParseAll is like Parse, but rather than stopping at the first error it
+// skips the offending transaction and keeps going.  It returns every
+// transaction parsed successfully along with a parse.ErrorList holding an
+// error for each one that wasn't.
+func ParseAll(name string, r io.Reader) ([]*Trans, error) {
+	pp := &Parser{}
+	err := pp.Parse(name, r, true)
+	return pp.Journal, err
+}
+
+// Load reads the named journal file from fsys along with every file it
+// includes and returns their transactions.
+func Load(fsys fs.FS, name string) ([]*Trans, error) {
+	pp := &Parser{FS: fsys}
+	if err := pp.Load(name, false); err != nil {
+		return nil, err
+	}
+	return pp.Journal, nil
+}
+
+// LoadAll is to Load what ParseAll is to Parse.
+func LoadAll(fsys fs.FS, name string) ([]*Trans, error) {
+	pp := &Parser{FS: fsys}
+	err := pp.Load(name, true)
+	return pp.Journal, err
+}
+
+type Parser struct {
+	Journal []*Trans
+	// DateFmt is the layout of transaction dates.  If empty, dates may be
+	// written year first with a four or two digit year, or without a year,
+	// using any of the separators '/', '-' or '.'.
+	DateFmt string
+	// Year is used for dates written without one.  It is set by year
+	// directives and defaults to the current year.
+	Year int
+	// FS holds the files named by include directives.  An include's path
+	// or glob pattern is taken relative to the directory of the including
+	// file unless it is absolute.  Without an FS, includes are errors.
+	FS fs.FS
+	// Directives holds every directive parsed, in the order written.
+	Directives []*Directive
+
+	currTrans     *Trans
+	currItem      *Item
+	notes         []lex.Token // text of notes not yet attached to anything
+	styles        map[string]*Style
+	marks         map[string]string // decimal mark of each commodity, by learnMarks
+	files         []string          // files being parsed, outermost first
+	recover       bool
+	handlers      map[string]DirectiveFunc
+	subLines      []string          // indented lines of the current directive
+	aliases       map[string]string // from alias directives
+	applied       []string          // accounts of apply account directives
+	bucketAccount string            // balances single item transactions
+	defaultCommod string            // of amounts written without one
+}
+
+// Parse parses r, appending its transactions to a.Journal.  If recover is
+// true, errors are collected into a parse.ErrorList rather than stopping the
+// parse as described for ParseAll.
+func (a *Parser) Parse(name string, r io.Reader, recover bool) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return a.parse(name, string(data), recover)
+}
+
+// Load parses the named file in a.FS as Parse does.
+func (a *Parser) Load(name string, recover bool) error {
+	if a.FS == nil {
+		return fmt.Errorf("no file system to load %v from", name)
+	}
+	data, err := fs.ReadFile(a.FS, name)
+	if err != nil {
+		return err
+	}
+	return a.parse(name, string(data), recover)
+}
+
+func (a *Parser) parse(name, input string, recover bool) error {
+	a.files = append(a.files, name)
+	a.recover = recover
+	defer func() { a.files = a.files[:len(a.files)-1] }()
+
+	a.learnMarks(name, input)
+	l := lex.New(name, input, lexStart)
+	p := parse.New(l, a.Start)
+	if recover {
+		p.Recover(a.Recover)
+	}
+	return p.Run()
+}
+
+// unexpected reports tok as a parse error.  Error tokens carry the lexer's
+// own message.
+func unexpected(p *parse.Parser, tok lex.Token) parse.StateFn {
+	if tok.Type == lex.TokError {
+		return p.Errorf(tok, "%v", tok.Val)
+	}
+	return p.Errorf(tok, "unexpected %v %q", tokNames[tok.Type], tok.Val)
+}
+
+func (a *Parser) pNote(p *parse.Parser) parse.StateFn {
+	tok := p.Next()
+	if tok.Type == tokMeta {
+		if tok = p.Next(); tok.Type != tokText {
+			return unexpected(p, tok)
+		}
+		a.notes = append(a.notes, tok)
+		tok = p.Next()
+	}
+
+	if tok.Type != tokNewline {
+		return unexpected(p, tok)
+	}
+	return nil
 }
 
 func (a *Parser) Start(p *parse.Parser) parse.StateFn {
-	fmt.Println("Start")
-	a.currNote = ""
+	a.notes = nil
 	switch tok := p.Peek(); tok.Type {
 	case lex.TokEOF:
 		return nil
 	case tokBeginTrans:
-		fmt.Printf("type %v: %+v\n", tokNames[tok.Type], tok)
 		return a.pTrans
 	case tokNewline:
+		p.Next()
 		return a.Start
 	case tokMeta:
 		p.Push(a.Start)
 		return a.pNote
+	case tokDirective:
+		return a.pDirective
 	default:
-		panic(fmt.Sprintf("unexpected token %v: '%v'", tokNames[tok.Type], tok.Val))
+		return unexpected(p, tok)
+	}
+}
+
+// include parses the files matching pattern, from the include directive at
+// tok.  The year set by a year directive in an included file doesn't carry
+// over to the including one.
+func (a *Parser) include(p *parse.Parser, tok lex.Token, pattern string) parse.StateFn {
+	if a.FS == nil {
+		return p.Errorf(tok, "cannot include files without a file system")
+	}
+	if !path.IsAbs(pattern) {
+		pattern = path.Join(path.Dir(p.Name()), pattern)
+	}
+	names, err := fs.Glob(a.FS, pattern)
+	if err != nil {
+		return p.Errorf(tok, "%v", err)
+	} else if len(names) == 0 {
+		return p.Errorf(tok, "no files to include match '%v'", pattern)
+	}
+
+	for _, name := range names {
+		for i, f := range a.files {
+			if f == name {
+				cycle := append(append([]string{}, a.files[i:]...), name)
+				return p.Errorf(tok, "include cycle: %v", strings.Join(cycle, " -> "))
+			}
+		}
+
+		year := a.Year
+		err := a.Load(name, a.recover)
+		a.Year = year
+		switch err := err.(type) {
+		case nil:
+		case *parse.Error:
+			p.Report(err)
+			return nil
+		case parse.ErrorList:
+			p.Report(err...)
+		default:
+			return p.Errorf(tok, "%v", err)
+		}
+	}
+	return nil
+}
+
+// Recover discards the rest of a failed transaction (or the offending
+// top-level token) and resumes parsing at the next transaction or
+// directive.
+func (a *Parser) Recover(p *parse.Parser) parse.StateFn {
+	a.notes = nil
+	a.currTrans = nil
+	a.currItem = nil
+	a.subLines = nil
+	for {
+		switch tok := p.Peek(); tok.Type {
+		case lex.TokEOF, tokBeginTrans, tokDirective:
+			return a.Start
+		case tokEndTrans:
+			p.Next()
+			return a.Start
+		}
+		p.Next()
 	}
 }
 
 func (a *Parser) pTrans(p *parse.Parser) parse.StateFn {
-	fmt.Println("Trans")
 	tok := p.Next()
 	if tok.Type != tokBeginTrans {
-		panic(fmt.Sprintf("unexpected token %v: '%v'", tokNames[tok.Type], tok.Val))
+		return unexpected(p, tok)
 	}
 
-	a.currTrans = &Trans{}
+	line, _ := p.Position(tok)
+	a.currTrans = &Trans{File: p.Name(), Line: line, pos: tok.Pos}
+	a.currItem = nil
 	p.Push(a.pEndTrans)
 	return a.pHeader
 }
+
 func (a *Parser) pEndTrans(p *parse.Parser) parse.StateFn {
-	fmt.Println("EndTrans")
+	if a.bucketAccount != "" && len(a.currTrans.Items) == 1 {
+		a.currTrans.Items = append(a.currTrans.Items, &Item{Account: a.bucketAccount})
+	}
+	a.currTrans.inheritTags()
 	a.Journal = append(a.Journal, a.currTrans)
 	return a.Start
 }
 
+// pItems handles the indented lines of a transaction - postings and note
+// lines - up through the end of the transaction.
+func (a *Parser) pItems(p *parse.Parser) parse.StateFn {
+	switch tok := p.Peek(); tok.Type {
+	case tokEndTrans:
+		p.Next()
+		return nil
+	case tokMeta:
+		p.Push(a.pItems)
+		p.Push(a.pLineNote)
+		return a.pNote
+	default:
+		p.Push(a.pItems)
+		return a.pItem
+	}
+}
+
+// pLineNote attaches a note on its own line to the preceding item or, if
+// there is none yet, to the transaction.
+func (a *Parser) pLineNote(p *parse.Parser) parse.StateFn {
+	if a.currItem != nil {
+		a.currItem.lineNote = a.currItem.lineNote || len(a.currItem.Notes) == 0
+		return a.pItemNotes(p)
+	}
+	a.currTrans.lineNote = a.currTrans.lineNote || len(a.currTrans.Notes) == 0
+	for _, tok := range a.notes {
+		a.currTrans.Notes = append(a.currTrans.Notes, tok.Val)
+		addTags(&a.currTrans.Tags, tok.Val)
+	}
+	a.notes = nil
+	return nil
+}
+
+// pItemNotes attaches the pending notes to the current item.
+func (a *Parser) pItemNotes(p *parse.Parser) parse.StateFn {
+	notes := a.notes
+	a.notes = nil
+	for _, tok := range notes {
+		a.currItem.Notes = append(a.currItem.Notes, tok.Val)
+		addTags(&a.currItem.Tags, tok.Val)
+		if err := a.itemAuxDate(a.currItem, tok.Val); err != nil {
+			return p.Errorf(tok, "%v", err)
+		}
+	}
+	return nil
+}
+
 func (a *Parser) pItem(p *parse.Parser) parse.StateFn {
-	fmt.Println("Item")
 	tok := p.Next()
 
 	a.currItem = &Item{}
 
 	// check for status
 	if tok.Type == tokStatus {
-		fmt.Println("status")
 		a.currItem.Status = tok.Val
 		tok = p.Next()
 	}
 
 	// check for account (required)
 	if tok.Type == tokAccount {
-		fmt.Println("account: ", tok.Val)
-		a.currItem.Account = tok.Val
-		tok = p.Next()
+		a.currItem.Account = a.account(tok.Val)
+		a.currItem.Line, _ = p.Position(tok)
 	} else {
-		panic(fmt.Sprintf("unexpected token %v: '%v'", tokNames[tok.Type], tok.Val))
+		return unexpected(p, tok)
 	}
 
 	p.Push(a.pEndItem)
 	p.Push(a.pNote)
+	p.Push(a.pAssert)
 	p.Push(a.pExchange)
 	return a.pAmount
 }
 
 func (a *Parser) pEndItem(p *parse.Parser) parse.StateFn {
-	fmt.Println("enditem")
-	a.currItem.Note = a.currNote
-	a.currNote = ""
 	a.currTrans.Items = append(a.currTrans.Items, a.currItem)
-	if tok := p.Peek(); tok.Type == tokEndTrans {
-		return nil
-	}
-	return a.pItem
+	return a.pItemNotes(p)
 }
 
-func (a *Parser) pAmount(p *parse.Parser) parse.StateFn {
-	fmt.Println("amount")
-	tok := p.Peek()
+// amount parses an optional amount: a number with a commodity either before
+// or after it, and a sign either before or after a prefix commodity.  It
+// returns nil if there is no amount and false if there was an error.
+func (a *Parser) amount(p *parse.Parser) (*Amount, bool) {
+	sign := p.Peek()
+	if sign.Type == tokSign {
+		p.Next()
+	}
+	unit := p.Peek()
+	if unit.Type == tokUnit {
+		p.Next()
+	}
 
-	if tok.Type == tokUnit {
-		tok = p.Next()
-		a.currItem.Commod = tok.Val
+	num := p.Peek()
+	if num.Type != tokAmount {
+		if sign.Type == tokSign || unit.Type == tokUnit {
+			unexpected(p, num)
+			return nil, false
+		}
+		return nil, true
 	}
+	p.Next()
 
-	switch tok := p.Peek(); tok.Type {
-	case tokNewline, tokEndTrans:
+	var style Style
+	var commod string
+	if unit.Type == tokUnit {
+		commod = unit.Val
+		style.Prefix = true
+		style.Space = unit.Pos+len(unit.Val) < num.Pos
+	} else if tok := p.Peek(); tok.Type == tokCommod {
 		p.Next()
-	case tokAmount:
-		tok = p.Next()
-		rat := big.NewRat(0, 1)
-		var success bool
-		a.currItem.Amount, success = rat.SetString(tok.Val)
-		if !success {
-			panic("invalid amount")
+		unit = tok
+		commod = tok.Val
+		style.Space = num.Pos+len(num.Val) < tok.Pos
+	}
+	if strings.HasPrefix(commod, `"`) {
+		if len(commod) < 2 || !strings.HasSuffix(commod, `"`) {
+			p.Errorf(unit, "unterminated quoted commodity")
+			return nil, false
 		}
+		commod = commod[1 : len(commod)-1]
+	} else if commod == "" {
+		commod = a.defaultCommod
+	}
+
+	known := a.marks[commod]
+	if s := a.styles[commod]; s != nil && s.Decimal != "" {
+		known = s.Decimal
+	}
+	if mark := decimalMark(num.Val); mark != "" && known != "" && mark != known {
+		p.Errorf(num, "amount '%v' uses '%v' as its decimal mark but %v amounts use '%v'", num.Val, mark, quoteCommod(commod), known)
+		return nil, false
+	}
+	qty, ok := parseNumber(num.Val, known, &style)
+	if !ok {
+		p.Errorf(num, "invalid amount '%v'", num.Val)
+		return nil, false
+	}
+	if sign.Type == tokSign && sign.Val == "-" {
+		qty.Neg(qty)
+	}
+	return &Amount{Qty: qty, Commod: commod, Style: a.style(commod, style)}, true
+}
 
-		if tok = p.Peek(); tok.Type == tokCommod {
-			tok = p.Next()
-			a.currItem.Commod = tok.Val
+// decimalMark returns the decimal mark the number token s must be using, or
+// "" if s has no mark or could be using either.  Either '.' or ',' may be
+// the decimal mark.  When both appear the last one is; a mark appearing more
+// than once is a thousands separator; and a single mark followed by exactly
+// three digits is ambiguous.
+func decimalMark(s string) string {
+	dot, comma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	switch {
+	case dot >= 0 && comma >= 0:
+		if comma > dot {
+			return ","
 		}
+		return "."
+	case dot < 0 && comma < 0:
+		return ""
+	}
+	i, c := dot, "."
+	if comma >= 0 {
+		i, c = comma, ","
+	}
+	switch {
+	case strings.Count(s, c) > 1:
+		return otherMark(c)
+	case len(s)-i-1 != 3:
+		return c
+	}
+	return ""
+}
+
+// parseNumber parses a number token, recording its separators and precision
+// in style.  The decimal mark of an ambiguous number is known, the mark
+// used by the commodity's other amounts, defaulting to '.'.
+func parseNumber(s string, known string, style *Style) (*big.Rat, bool) {
+	mark := decimalMark(s)
+	if mark == "" {
+		mark = known
+	}
+	if mark == "" {
+		mark = "."
+	}
+
+	if sep := otherMark(mark); strings.Contains(s, sep) {
+		style.Thousands = sep
+		style.Decimal = mark
+		s = strings.Replace(s, sep, "", -1)
+	}
+	if i := strings.Index(s, mark); i >= 0 {
+		style.Decimal = mark
+		style.Precision = len(s) - i - 1
+		s = s[:i] + "." + s[i+1:]
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// learnMarks scans input for the decimal mark of each commodity, taken from
+// the first of its amounts that isn't ambiguous, so that ambiguous amounts
+// written before it are read the same way.
+func (a *Parser) learnMarks(name, input string) {
+	if a.marks == nil {
+		a.marks = map[string]string{}
+	}
+	var unit, num lex.Token
+	learn := func(commod string) {
+		commod = unquote(commod)
+		if _, ok := a.marks[commod]; !ok {
+			if mark := decimalMark(num.Val); mark != "" {
+				a.marks[commod] = mark
+			}
+		}
+	}
+	for tok := range lex.New(name, input, lexStart).Tokens {
+		switch tok.Type {
+		case tokUnit:
+			unit = tok
+		case tokAmount:
+			num = tok
+			if unit.Type == tokUnit {
+				learn(unit.Val)
+			}
+		case tokCommod:
+			if num.Type == tokAmount && unit.Type != tokUnit {
+				learn(tok.Val)
+			}
+		}
+		if tok.Type != tokUnit && tok.Type != tokSign && tok.Type != tokAmount {
+			unit, num = lex.Token{}, lex.Token{}
+		}
+	}
+}
+
+func otherMark(mark string) string {
+	if mark == "." {
+		return ","
+	}
+	return "."
+}
+
+// style returns the shared style for commod after merging in how it was
+// just written.
+func (a *Parser) style(commod string, written Style) *Style {
+	if a.styles == nil {
+		a.styles = map[string]*Style{}
+	}
+	if s, ok := a.styles[commod]; ok {
+		s.learn(written)
+		return s
+	}
+	a.styles[commod] = &written
+	return &written
+}
+
+func (a *Parser) pAmount(p *parse.Parser) parse.StateFn {
+	a.currItem.Amount, _ = a.amount(p)
+	if a.currItem.Amount != nil {
+		return a.pLot
 	}
 	return nil
 }
 
-func (a *Parser) pExAmount(p *parse.Parser) parse.StateFn {
-	fmt.Println("examount")
+// pLot parses the lot annotations following an item's amount.
+func (a *Parser) pLot(p *parse.Parser) parse.StateFn {
 	tok := p.Peek()
-
-	if tok.Type == tokUnit {
-		tok = p.Next()
-		a.currItem.ExCommod = tok.Val
+	if tok.Type != tokLBrace && tok.Type != tokLotDate && tok.Type != tokLotNote {
+		return nil
 	}
+	p.Next()
 
-	switch tok := p.Peek(); tok.Type {
-	case tokNewline, tokEndTrans:
-		p.Next()
-	case tokAmount:
-		tok = p.Next()
-		rat := big.NewRat(0, 1)
-		var success bool
-		a.currItem.ExAmount, success = rat.SetString(tok.Val)
-		if !success {
-			panic("invalid amount")
+	amt := a.currItem.Amount
+	if amt.Lot == nil {
+		amt.Lot = &Lot{}
+	}
+	switch tok.Type {
+	case tokLBrace:
+		price, ok := a.amount(p)
+		if !ok {
+			return nil
+		} else if price == nil {
+			return unexpected(p, p.Next())
+		} else if end := p.Next(); end.Type != tokRBrace {
+			return unexpected(p, end)
 		}
-		if tok = p.Peek(); tok.Type == tokCommod {
-			tok = p.Next()
-			a.currItem.ExCommod = tok.Val
+		amt.Lot.Price = price
+	case tokLotDate:
+		var err error
+		if amt.Lot.Date, err = a.parseDate(tok.Val, a.Year); err != nil {
+			return p.Errorf(tok, "invalid lot date '%v'", tok.Val)
 		}
+	case tokLotNote:
+		amt.Lot.Note = tok.Val
+	}
+	return a.pLot
+}
+
+func (a *Parser) pCost(p *parse.Parser) parse.StateFn {
+	amt, ok := a.amount(p)
+	if ok && amt == nil {
+		return unexpected(p, p.Next())
+	} else if ok {
+		a.currItem.Cost.Amount = *amt
 	}
 	return nil
 }
 
 func (a *Parser) pExchange(p *parse.Parser) parse.StateFn {
 	tok := p.Peek()
-	if tok.Type != tokAt || tok.Type != tokAtAt {
-		return a.pExAmount
+	if tok.Type != tokAt && tok.Type != tokAtAt {
+		return nil
+	}
+	p.Next()
+	if a.currItem.Amount == nil {
+		p.Errorf(tok, "cost given for an item with no amount")
+		return nil
+	}
+	a.currItem.Cost = &Cost{Total: tok.Type == tokAtAt}
+	return a.pCost
+}
+
+// pAssert parses a balance assertion or assignment: "=" and an amount.
+func (a *Parser) pAssert(p *parse.Parser) parse.StateFn {
+	tok := p.Peek()
+	if tok.Type != tokAssert {
+		return nil
+	}
+	p.Next()
+	amt, ok := a.amount(p)
+	if ok && amt == nil {
+		return unexpected(p, p.Next())
+	} else if ok {
+		a.currItem.Assert = amt
 	}
 	return nil
 }
@@ -196,13 +692,21 @@ func (a *Parser) pHeader(p *parse.Parser) parse.StateFn {
 	// check for date (required)
 	if tok.Type == tokDate {
 		var err error
-		a.currTrans.Date, err = time.Parse("06/1/2", tok.Val)
-		if err != nil {
-			panic(err.Error())
+		if a.currTrans.Date, err = a.parseDate(tok.Val, a.Year); err != nil {
+			return p.Errorf(tok, "invalid date '%v'", tok.Val)
 		}
 		tok = p.Next()
 	} else {
-		panic(fmt.Sprintf("unexpected token %v: '%v'", tokNames[tok.Type], tok.Val))
+		return unexpected(p, tok)
+	}
+
+	// check for auxiliary date
+	if tok.Type == tokAuxDate {
+		var err error
+		if a.currTrans.AuxDate, err = a.parseDate(tok.Val, a.currTrans.Date.Year()); err != nil {
+			return p.Errorf(tok, "invalid date '%v'", tok.Val)
+		}
+		tok = p.Next()
 	}
 
 	// check for status
@@ -211,22 +715,80 @@ func (a *Parser) pHeader(p *parse.Parser) parse.StateFn {
 		tok = p.Next()
 	}
 
+	// check for code
+	if tok.Type == tokCode {
+		a.currTrans.Code = strings.TrimSpace(tok.Val)
+		tok = p.Next()
+	}
+
 	// check for payee (required)
 	if tok.Type == tokPayee {
-		a.currTrans.Descrip = tok.Val
+		a.currTrans.Descrip = strings.TrimSpace(tok.Val)
 		tok = p.Next()
 	} else {
-		panic(fmt.Sprintf("unexpected token %v: '%v'", tokNames[tok.Type], tok.Val))
+		return unexpected(p, tok)
 	}
 
 	// check for note
 	if tok.Type == tokMeta {
-		a.currTrans.Note = p.Next().Val
+		note := p.Next().Val
+		a.currTrans.Notes = append(a.currTrans.Notes, note)
+		addTags(&a.currTrans.Tags, note)
 		tok = p.Next()
 	}
 
 	if tok.Type != tokNewline {
-		panic(fmt.Sprintf("unexpected token %v: '%v'", tokNames[tok.Type], tok.Val))
+		return unexpected(p, tok)
+	}
+	return a.pItems
+}
+
+// parseDate interprets a date token according to a.DateFmt or, if it is
+// empty, by the number and length of its fields.  Dates without a year are
+// placed in year or, if that is zero, the current year.
+func (a *Parser) parseDate(s string, year int) (time.Time, error) {
+	if a.DateFmt != "" {
+		return time.Parse(a.DateFmt, s)
+	}
+
+	i := strings.IndexAny(s, datesep)
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("invalid date '%v'", s)
 	}
-	return a.pItem
+	sep := s[i]
+	fields := strings.Split(s, string(sep))
+	if len(fields) != 2 && len(fields) != 3 {
+		return time.Time{}, fmt.Errorf("invalid date '%v'", s)
+	}
+	if len(fields) == 2 {
+		// parse with the real year so 02/29 is only valid in leap years
+		if year == 0 {
+			year = time.Now().Year()
+		}
+		layout := strings.Join([]string{"2006", "1", "2"}, string(sep))
+		return time.Parse(layout, fmt.Sprintf("%04d%c%v", year, sep, s))
+	}
+
+	layout := "06"
+	if len(fields[0]) == 4 {
+		layout = "2006"
+	}
+	return time.Parse(strings.Join([]string{layout, "1", "2"}, string(sep)), s)
+}
+
+// auxDateRe matches an item's auxiliary date in its note, e.g. [=2024/01/10].
+var auxDateRe = regexp.MustCompile(`\[=([^\]]+)\]`)
+
+// itemAuxDate sets it.AuxDate from note, if the note has one.
+func (a *Parser) itemAuxDate(it *Item, note string) error {
+	m := auxDateRe.FindStringSubmatch(note)
+	if m == nil {
+		return nil
+	}
+	d, err := a.parseDate(m[1], a.currTrans.Date.Year())
+	if err != nil {
+		return fmt.Errorf("invalid date '%v'", m[1])
+	}
+	it.AuxDate = d
+	return nil
 }
@@ -1,18 +1,384 @@
 package ledger
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
 
-	"github.com/rwcarlsen/goledger/lex"
 	"github.com/rwcarlsen/goledger/parse"
 )
 
 func TestParse(t *testing.T) {
-	l := lex.New("testlex", journal1, lexStart)
-	pp := &Parser{}
-	p := parse.New(l, pp.Start)
-	p.Run()
-	for _, trans := range pp.Journal {
+	journal, err := Parse("journal1", strings.NewReader(journal1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trans := range journal {
 		t.Logf("%+v", trans)
 	}
+
+	if len(journal) != 1 {
+		t.Fatalf("got %v transactions, want 1", len(journal))
+	}
+	trans := journal[0]
+	if trans.Descrip != "Just an example" {
+		t.Errorf("got payee '%v'", trans.Descrip)
+	}
+	if len(trans.Items) != 2 {
+		t.Fatalf("got %v items, want 2", len(trans.Items))
+	}
+	if it := trans.Items[0]; it.Amount.Commod != "$" || it.Amount.Qty.FloatString(2) != "100.00" {
+		t.Errorf("got amount %v", it.Amount)
+	}
+	if it := trans.Items[1]; it.Account != "Income:Another bar:Account" || it.Amount != nil {
+		t.Errorf("got item %+v", it)
+	}
+}
+
+var errTests = []struct {
+	input     string
+	line, col int
+}{
+	{"10/05/31 Payee\n    Assets  $1\n10/13/31 Payee\n", 3, 1},
+	{"10/05/31\n    Assets  $1\n", 1, 9},
+	{"10/05/31 Payee\n    Assets  $1\n    Expenses  $1 @@@ 2\n", 3, 18},
+	{"bogus\n", 1, 1},
+}
+
+func TestParseErrors(t *testing.T) {
+	for i, test := range errTests {
+		_, err := Parse("bad", strings.NewReader(test.input))
+		perr, ok := err.(*parse.Error)
+		if !ok {
+			t.Errorf("test %v: expected *parse.Error, got %v", i, err)
+			continue
+		}
+		t.Log(perr)
+		if perr.Name != "bad" || perr.Line != test.line || perr.Col != test.col {
+			t.Errorf("test %v: got position %v:%v, want %v:%v", i, perr.Line, perr.Col, test.line, test.col)
+		}
+	}
+}
+
+const badJournal = `
+10/05/31 Good one
+    Expenses:Food      $10
+    Assets:Checking
+
+10/13/01 Bad date
+    Expenses:Food      $10
+    Assets:Checking
+; a top-level comment
+bogus line
+10/06/02 Bad amount
+    Expenses:Food      $10 @@@ 3
+    Assets:Checking
+
+10/06/03 Another good one
+    Expenses:Food      $12
+    Assets:Checking
+`
+
+func TestParseAll(t *testing.T) {
+	journal, err := ParseAll("bad", strings.NewReader(badJournal))
+	errs, ok := err.(parse.ErrorList)
+	if !ok {
+		t.Fatalf("expected parse.ErrorList, got %v", err)
+	}
+
+	lines := []int{6, 10, 12}
+	if len(errs) != len(lines) {
+		t.Fatalf("got %v errors, want %v: %v", len(errs), len(lines), errs)
+	}
+	for i, e := range errs {
+		t.Log(e)
+		if e.Line != lines[i] {
+			t.Errorf("error %v: got line %v, want %v", i, e.Line, lines[i])
+		}
+	}
+
+	if len(journal) != 2 {
+		t.Fatalf("got %v transactions, want 2", len(journal))
+	} else if journal[0].Descrip != "Good one" || journal[1].Descrip != "Another good one" {
+		t.Errorf("got transactions %+v, %+v", journal[0], journal[1])
+	}
+}
+
+const dates = `
+2009/05/14 Slashes
+    A  $1
+    B
+
+2024-05-14 Dashes
+    A  $1
+    B
+
+2024.05.14 Dots
+    A  $1
+    B
+
+24/5/4 Short year
+    A  $1
+    B
+
+year 2021
+
+05/14 No year
+    A  $1
+    B
+`
+
+func TestParseDates(t *testing.T) {
+	journal, err := Parse("dates", strings.NewReader(dates))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2009/05/14", "2024/05/14", "2024/05/14", "2024/05/04", "2021/05/14"}
+	if len(journal) != len(want) {
+		t.Fatalf("got %v transactions, want %v", len(journal), len(want))
+	}
+	for i, trans := range journal {
+		if got := trans.Date.Format(dateFmt); got != want[i] {
+			t.Errorf("%v: got date %v, want %v", trans.Descrip, got, want[i])
+		}
+	}
+
+	pp := &Parser{DateFmt: "01/02/2006"}
+	if err := pp.Parse("us", strings.NewReader("05/14/2024 US style\n    A  $1\n    B\n"), false); err != nil {
+		t.Fatal(err)
+	} else if got := pp.Journal[0].Date.Format(dateFmt); got != "2024/05/14" {
+		t.Errorf("got date %v, want 2024/05/14", got)
+	}
+
+	pp = &Parser{Year: 2024}
+	if err := pp.Parse("leap", strings.NewReader("02/29 Leap day\n    A  $1\n    B\n"), false); err != nil {
+		t.Fatal(err)
+	} else if got := pp.Journal[0].Date.Format(dateFmt); got != "2024/02/29" {
+		t.Errorf("got date %v, want 2024/02/29", got)
+	}
+
+	for _, bad := range []string{"2024/05-14 X\n", "2024/05/14x X\n", "2024/13/01 X\n", "2024 X\n", "year 2023\n02/29 X\n"} {
+		if _, err := Parse("bad", strings.NewReader(bad)); err == nil {
+			t.Errorf("'%v': expected error", strings.TrimSpace(bad))
+		}
+	}
+}
+
+func TestParseCode(t *testing.T) {
+	const input = "2024/01/05 * (1042) Landlord\n    Expenses:Rent  $900\n    Assets:Checking\n2024/01/06 (unfinished Payee\n    A  $1\n    B\n"
+	journal, err := ParseAll("codes", strings.NewReader(input))
+	if errs, ok := err.(parse.ErrorList); !ok || len(errs) != 1 || errs[0].Line != 4 {
+		t.Errorf("expected a single error on line 4, got %v", err)
+	}
+	if len(journal) != 1 {
+		t.Fatalf("got %v transactions, want 1", len(journal))
+	}
+	if trans := journal[0]; trans.Code != "1042" || trans.Descrip != "Landlord" || trans.Status != "*" {
+		t.Errorf("got code '%v', payee '%v', status '%v'", trans.Code, trans.Descrip, trans.Status)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	const input = `2024/01/05 Travel  ; :trip:work:
+    ; Project: apollo
+    Expenses:Hotel   $200  ; Room: 12
+    ; :receipt:
+    Assets:Checking  ; Project: gemini
+    Assets:Cash  ; :a: :b: and :c:d:
+`
+	journal, err := Parse("tags", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans := journal[0]
+	tagStr := func(tags map[string]string) string {
+		var s []string
+		for k, v := range tags {
+			s = append(s, k+"="+v)
+		}
+		sort.Strings(s)
+		return strings.Join(s, ",")
+	}
+
+	if got := tagStr(trans.Tags); got != "Project=apollo,trip=,work=" {
+		t.Errorf("got transaction tags %v", got)
+	}
+	if got := tagStr(trans.Items[0].Tags); got != "Project=apollo,Room=12,receipt=,trip=,work=" {
+		t.Errorf("got first item tags %v", got)
+	}
+	if got := tagStr(trans.Items[1].Tags); got != "Project=gemini,trip=,work=" {
+		t.Errorf("got second item tags %v", got)
+	}
+	if got := tagStr(trans.Items[2].Tags); got != "Project=apollo,a=,b=,c=,d=,trip=,work=" {
+		t.Errorf("got third item tags %v", got)
+	}
+}
+
+func TestParseCommodities(t *testing.T) {
+	tests := []struct {
+		amount string
+		qty    string
+		commod string
+		want   string
+	}{
+		{"$5.32", "5.32", "$", "$5.32"},
+		{"-$5.32", "-5.32", "$", "$-5.32"},
+		{"$-5.32", "-5.32", "$", "$-5.32"},
+		{"€12", "12", "€", "€12"},
+		{"£ -3.5", "-3.5", "£", "£ -3.5"},
+		{"EUR 1.234,56", "1234.56", "EUR", "EUR 1.234,56"},
+		{"-EUR 0,5", "-0.5", "EUR", "EUR -0,5"},
+		{"1.234.567 JPY", "1234567", "JPY", "1.234.567 JPY"},
+		{"1,234.5 USD", "1234.5", "USD", "1,234.5 USD"},
+		{"-10 GAL", "-10", "GAL", "-10 GAL"},
+		{`3 "S&P 500"`, "3", "S&P 500", `3 "S&P 500"`},
+		{`"ABC 1"-2.5`, "-2.5", "ABC 1", `"ABC 1"-2.5`},
+	}
+	for _, test := range tests {
+		input := "2024/01/05 Test\n    A  " + test.amount + "  ; note\n    B\n"
+		journal, err := Parse("commods", strings.NewReader(input))
+		if err != nil {
+			t.Errorf("%v: %v", test.amount, err)
+			continue
+		}
+		amt := journal[0].Items[0].Amount
+		if amt == nil || decimal(amt.Qty) != test.qty || amt.Commod != test.commod {
+			t.Errorf("%v: got %v", test.amount, amt)
+		} else if got := amt.String(); got != test.want {
+			t.Errorf("%v: formatted as %q, want %q", test.amount, got, test.want)
+		}
+	}
+}
+
+func TestParseAmbiguousNumbers(t *testing.T) {
+	const input = `2024/01/05 Test
+    A  EUR 1.234,56
+    A  EUR 1,234
+    A  1,234 USD
+    A  1.234 USD
+    B
+`
+	journal, err := Parse("ambiguous", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1234.56", "1.234", "1234", "1.234"}
+	for i, w := range want {
+		if got := decimal(journal[0].Items[i].Amount.Qty); got != w {
+			t.Errorf("item %v: got %v, want %v", i, got, w)
+		}
+	}
+
+	// the decimal mark of a later amount decides an earlier ambiguous one
+	journal, err = Parse("later", strings.NewReader("2024/01/05 Test\n    A  EUR 1,234\n    A  EUR 5,5\n    B\n"))
+	if err != nil {
+		t.Fatal(err)
+	} else if got := decimal(journal[0].Items[0].Amount.Qty); got != "1.234" {
+		t.Errorf("got %v, want 1.234", got)
+	}
+
+	_, err = Parse("conflict", strings.NewReader("2024/01/05 Test\n    A  EUR 5,5\n    A  EUR 1.5\n    B\n"))
+	if err == nil || !strings.Contains(err.Error(), "decimal mark") {
+		t.Errorf("got error %v, want conflicting decimal marks", err)
+	}
+
+	_, err = Parse("unterminated", strings.NewReader("2024/01/05 Test\n    A  3 \"S&P 500\n    B\n"))
+	if err == nil || !strings.Contains(err.Error(), "unterminated quoted commodity") {
+		t.Errorf("got error %v, want an unterminated quoted commodity", err)
+	}
+}
+
+func TestLoadIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"books/main.ledger":       {Data: []byte("year 2024\ninclude years/*.ledger\n\n2024/03/01 Main\n    A  $1\n    B\n")},
+		"books/years/2022.ledger": {Data: []byte("2022/01/01 Old\n    A  $1\n    B\n")},
+		"books/years/2023.ledger": {Data: []byte("include ../shared.ledger  ; common accounts\n02/01 Newer\n    A  $2\n    B\n")},
+		"books/shared.ledger":     {Data: []byte("year 2020\n\n01/01 Shared\n    A  $3\n    B\n")},
+	}
+	journal, err := Load(fsys, "books/main.ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, trans := range journal {
+		got = append(got, fmt.Sprintf("%v:%v %v %v", trans.File, trans.Line, trans.Date.Format(dateFmt), trans.Descrip))
+	}
+	want := []string{
+		"books/years/2022.ledger:1 2022/01/01 Old",
+		"books/shared.ledger:3 2020/01/01 Shared",
+		"books/years/2023.ledger:2 2024/02/01 Newer",
+		"books/main.ledger:4 2024/03/01 Main",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadIncludeErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.ledger":      {Data: []byte("include b.ledger\n")},
+		"b.ledger":      {Data: []byte("2024/01/01 B\n    A  $1\n    B\ninclude a.ledger\n")},
+		"bad.ledger":    {Data: []byte("include missing.ledger\ninclude broken.ledger\n2024/01/01 After\n    A  $1\n    B\n")},
+		"broken.ledger": {Data: []byte("2024/01/01 Broken\n    A  $1 $2\n    B\n2024/01/02 Fine\n    A  $1\n    B\n")},
+	}
+
+	_, err := Load(fsys, "a.ledger")
+	if e, ok := err.(*parse.Error); !ok || e.Name != "b.ledger" || e.Line != 4 || !strings.Contains(e.Msg, "a.ledger -> b.ledger -> a.ledger") {
+		t.Errorf("got error %v, want an include cycle on b.ledger:4", err)
+	}
+
+	journal, err := LoadAll(fsys, "bad.ledger")
+	errs, ok := err.(parse.ErrorList)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("got error %v, want two errors", err)
+	}
+	if errs[0].Name != "bad.ledger" || errs[0].Line != 1 || errs[1].Name != "broken.ledger" || errs[1].Line != 2 {
+		t.Errorf("got errors %v and %v", errs[0], errs[1])
+	}
+	if len(journal) != 2 || journal[0].Descrip != "Fine" || journal[1].Descrip != "After" {
+		t.Errorf("got %v transactions, want Fine and After", len(journal))
+	}
+
+	if _, err := Parse("stdin", strings.NewReader("include a.ledger\n")); err == nil {
+		t.Errorf("expected an error including without a file system")
+	}
+}
+
+// BenchmarkParse parses a large journal, which should take time linear in
+// its size.
+func BenchmarkParse(b *testing.B) {
+	var buf strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "2023/%02d/%02d Payee %v\n", i%12+1, i%28+1, i)
+		fmt.Fprintf(&buf, "    Expenses:Food  $%v.25\n", i)
+		fmt.Fprintf(&buf, "    Assets:Checking\n\n")
+	}
+	input := buf.String()
+	b.SetBytes(int64(len(input)))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse("bench", strings.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestItemLines(t *testing.T) {
+	input := "2024/01/01 One\n    A  $1\n    B\n\n; comment\n2024/01/02 Two\n    ; note\n    A  $2 = $3\n    C  €1 @ $2\n    B\n"
+	journal, err := Parse("lines", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, trans := range journal {
+		for _, it := range trans.Items {
+			got = append(got, it.Line)
+		}
+	}
+	if want := []int{2, 3, 8, 9, 10}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got item lines %v, want %v", got, want)
+	}
 }
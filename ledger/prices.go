@@ -0,0 +1,262 @@
+package ledger
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Price is the price of one unit of a commodity on a date.
+type Price struct {
+	Date   time.Time
+	Commod string
+	Price  Amount
+}
+
+// PriceDB is a history of commodity prices, from P directives and the costs
+// of items, used to value amounts in other commodities.
+type PriceDB struct {
+	prices map[string][]Price         // keyed by commodity and price commodity, in date order
+	links  map[string]map[string]bool // commodities priced in, or pricing, each commodity
+	styles map[string]*Style
+}
+
+// NewPriceDB returns an empty price database.
+func NewPriceDB() *PriceDB {
+	return &PriceDB{
+		prices: map[string][]Price{},
+		links:  map[string]map[string]bool{},
+		styles: map[string]*Style{},
+	}
+}
+
+// String formats p as a P directive.
+func (p Price) String() string {
+	date := p.Date.Format(dateFmt)
+	if h, m, s := p.Date.Clock(); h != 0 || m != 0 || s != 0 {
+		date += p.Date.Format(" 15:04:05")
+	}
+	return fmt.Sprintf("P %v %v %v", date, quoteCommod(p.Commod), p.Price)
+}
+
+func priceKey(commod, in string) string { return commod + "\x00" + in }
+
+// Add records price as the price of one unit of commod on date.  A price
+// recorded later for the same date takes precedence.  Prices of a
+// commodity in itself are ignored.
+func (db *PriceDB) Add(date time.Time, commod string, price Amount) {
+	if commod == price.Commod {
+		return
+	}
+	price.Lot = nil
+	key := priceKey(commod, price.Commod)
+	prices := db.prices[key]
+	i := sort.Search(len(prices), func(i int) bool { return prices[i].Date.After(date) })
+	prices = append(prices, Price{})
+	copy(prices[i+1:], prices[i:])
+	prices[i] = Price{Date: date, Commod: commod, Price: price}
+	db.prices[key] = prices
+
+	db.link(commod, price.Commod)
+	db.link(price.Commod, commod)
+	if _, ok := db.styles[price.Commod]; !ok && price.Style != nil {
+		db.styles[price.Commod] = price.Style
+	}
+}
+
+func (db *PriceDB) link(a, b string) {
+	if db.links[a] == nil {
+		db.links[a] = map[string]bool{}
+	}
+	db.links[a][b] = true
+}
+
+// AddDirectives records the prices given by P directives.
+func (db *PriceDB) AddDirectives(directives []*Directive) {
+	for _, d := range directives {
+		if p, ok := d.Value.(*PriceDirective); ok {
+			db.Add(p.Date, p.Commod, p.Price)
+		}
+	}
+}
+
+// AddJournal records the implicit prices of items with a @ or @@ cost as of
+// their date.
+func (db *PriceDB) AddJournal(journal []*Trans) {
+	for _, t := range journal {
+		for _, it := range t.Items {
+			if it.Cost == nil || it.Amount == nil || it.Amount.IsZero() {
+				continue
+			}
+			if _, ok := db.styles[it.Amount.Commod]; !ok && it.Amount.Style != nil {
+				db.styles[it.Amount.Commod] = it.Amount.Style
+			}
+			db.Add(t.ItemDate(it, false), it.Amount.Commod, *it.Price())
+		}
+	}
+}
+
+// Parse reads the prices of the P directives in r, such as a price history
+// kept apart from the journal.  Transactions and other directives in r are
+// parsed but otherwise ignored.
+func (db *PriceDB) Parse(name string, r io.Reader) error {
+	pp := &Parser{}
+	if err := pp.Parse(name, r, false); err != nil {
+		return err
+	}
+	db.AddDirectives(pp.Directives)
+	return nil
+}
+
+// Load reads the prices of the named file in fsys as Parse does.
+func (db *PriceDB) Load(fsys fs.FS, name string) error {
+	pp := &Parser{FS: fsys}
+	if err := pp.Load(name, false); err != nil {
+		return err
+	}
+	db.AddDirectives(pp.Directives)
+	return nil
+}
+
+// Prices returns every recorded price ordered by commodity, then date and
+// then price commodity.
+func (db *PriceDB) Prices() []Price {
+	var all []Price
+	for _, prices := range db.prices {
+		all = append(all, prices...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.Commod != b.Commod {
+			return a.Commod < b.Commod
+		} else if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		return a.Price.Commod < b.Price.Commod
+	})
+	return all
+}
+
+// Price returns the price of one unit of commod in target as of date, using
+// the latest price recorded on or before date.  A price of target in commod
+// is inverted, and without any price between the two the conversion goes
+// through the fewest intermediate commodities possible.  It returns false
+// if no prices connect commod to target by date.  A zero date uses the
+// latest prices.
+func (db *PriceDB) Price(commod, target string, date time.Time) (Amount, bool) {
+	if date.IsZero() {
+		date = endOfTime
+	}
+	rate, ok := db.rate(commod, target, date)
+	if !ok {
+		return Amount{}, false
+	}
+	return Amount{Qty: rate, Commod: target, Style: db.styles[target]}, true
+}
+
+// Value returns amt converted to target as of date along with whether it
+// could be.  Lot annotations are dropped.
+func (db *PriceDB) Value(amt Amount, target string, date time.Time) (Amount, bool) {
+	price, ok := db.Price(amt.Commod, target, date)
+	if !ok {
+		return Amount{}, false
+	}
+	return price.Mul(amt.qty()), true
+}
+
+// rate searches breadth first for the shortest chain of prices from commod
+// to target.
+func (db *PriceDB) rate(commod, target string, date time.Time) (*big.Rat, bool) {
+	if commod == target {
+		return big.NewRat(1, 1), true
+	}
+	rates := map[string]*big.Rat{commod: big.NewRat(1, 1)}
+	queue := []string{commod}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+
+		var next []string
+		for to := range db.links[from] {
+			next = append(next, to)
+		}
+		sort.Strings(next)
+		for _, to := range next {
+			if _, seen := rates[to]; seen {
+				continue
+			}
+			r, ok := db.direct(from, to, date)
+			if !ok {
+				continue
+			}
+			rates[to] = new(big.Rat).Mul(rates[from], r)
+			if to == target {
+				return rates[to], true
+			}
+			queue = append(queue, to)
+		}
+	}
+	return nil, false
+}
+
+// direct returns the price of one unit of commod in target from the most
+// recent price, on or before date, of either one in the other.
+func (db *PriceDB) direct(commod, target string, date time.Time) (*big.Rat, bool) {
+	p, ok := db.latest(commod, target, date)
+	q, inv := db.latest(target, commod, date)
+	if inv && q.Price.Sign() != 0 && (!ok || q.Date.After(p.Date)) {
+		return new(big.Rat).Inv(q.Price.qty()), true
+	}
+	return p.Price.qty(), ok
+}
+
+func (db *PriceDB) latest(commod, in string, date time.Time) (Price, bool) {
+	prices := db.prices[priceKey(commod, in)]
+	i := sort.Search(len(prices), func(i int) bool { return prices[i].Date.After(date) })
+	if i == 0 {
+		return Price{}, false
+	}
+	return prices[i-1], true
+}
+
+// Valuation converts amounts to a single commodity at market prices.
+type Valuation struct {
+	Prices *PriceDB
+	Commod string // the commodity amounts are valued in
+	// Date fixes the date whose prices are used.  If zero, balance reports
+	// use the latest prices recorded and register reports the prices as of
+	// each posting's date.
+	Date time.Time
+}
+
+// endOfTime is a date after every recorded price.
+var endOfTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Value returns the value in v.Commod of every amount in b that prices
+// connect to v.Commod as of date, along with the remaining amounts that
+// could not be converted.  A zero date uses the latest prices.
+func (v *Valuation) Value(b Balance, date time.Time) (Amount, Balance) {
+	value := Amount{Qty: new(big.Rat), Commod: v.Commod, Style: v.Prices.styles[v.Commod]}
+	rest := Balance{}
+	for _, amt := range b.Amounts() {
+		if conv, ok := v.Prices.Value(amt, v.Commod, date); ok {
+			value = value.Add(conv)
+		} else {
+			rest.Add(amt)
+		}
+	}
+	return value, rest
+}
+
+// balance returns b valued as of date with the unconverted remainder kept
+// as separate amounts.
+func (v *Valuation) balance(b Balance, date time.Time) Balance {
+	value, valued := v.Value(b, date)
+	if !value.IsZero() || len(valued) == 0 {
+		valued.Add(value)
+	}
+	return valued
+}
@@ -0,0 +1,216 @@
+package ledger
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const prices = `
+P 2024/01/01 AAPL $150
+P 2024/03/01 AAPL $172.50
+P 2024/03/01 00:00:00 EUR $1.10
+P 2024/02/01 BTC 40,000 EUR
+
+2024/02/15 Buy
+    Assets:Brokerage    10 VTI @ $230.00
+    Assets:Checking
+
+2024/02/20 Sell
+    Assets:Brokerage    -5 VTI @@ $1,200
+    Assets:Checking
+`
+
+func date(s string) time.Time {
+	t, err := time.Parse(dateFmt, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestPriceDB(t *testing.T) {
+	pp := &Parser{}
+	if err := pp.Parse("prices", strings.NewReader(prices), false); err != nil {
+		t.Fatal(err)
+	}
+	db := NewPriceDB()
+	db.AddDirectives(pp.Directives)
+	db.AddJournal(pp.Journal)
+
+	tests := []struct {
+		commod, target, date, want string
+	}{
+		{"AAPL", "$", "2024/01/01", "150"},
+		{"AAPL", "$", "2024/02/29", "150"},
+		{"AAPL", "$", "2024/03/01", "345/2"},
+		{"AAPL", "$", "2023/12/31", ""},
+		{"VTI", "$", "2024/02/15", "230"},
+		{"VTI", "$", "2024/02/20", "240"},
+		{"$", "VTI", "2024/03/01", "1/240"},
+		{"BTC", "$", "2024/02/01", ""},
+		{"BTC", "$", "2024/03/01", "44000"},
+		{"AAPL", "BTC", "2024/03/01", "69/17600"},
+		{"$", "$", "2020/01/01", "1"},
+	}
+	for _, test := range tests {
+		got := ""
+		if price, ok := db.Price(test.commod, test.target, date(test.date)); ok {
+			got = price.Qty.RatString()
+			if price.Commod != test.target {
+				t.Errorf("price of %v in %v: got commodity %v", test.commod, test.target, price.Commod)
+			}
+		}
+		if got != test.want {
+			t.Errorf("price of %v in %v on %v: got %q, want %q", test.commod, test.target, test.date, got, test.want)
+		}
+	}
+
+	if p, _ := db.Price("BTC", "$", date("2024/03/01")); p.String() != "$44,000.00" {
+		t.Errorf("got price %v, want it in the style of $", p)
+	}
+	aapl := Amount{Qty: big.NewRat(3, 1), Commod: "AAPL"}
+	if v, ok := db.Value(aapl, "EUR", date("2024/03/01")); !ok || v.Qty.RatString() != "5175/11" {
+		t.Errorf("got value %v, %v", v.Qty.RatString(), ok)
+	}
+}
+
+func TestPriceDBLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"prices.db": {Data: []byte("P 2024/01/01 12:00:00 AAPL $150\nP 2024/01/02 12:00:00 AAPL $155\n")},
+	}
+	db := NewPriceDB()
+	if err := db.Load(fsys, "prices.db"); err != nil {
+		t.Fatal(err)
+	}
+	if all := db.Prices(); len(all) != 2 || all[1].String() != "P 2024/01/02 12:00:00 AAPL $155" {
+		t.Errorf("got prices %v", all)
+	}
+	if p, ok := db.Price("AAPL", "$", date("2024/01/01")); ok {
+		t.Errorf("got price %v before the first one recorded", p)
+	}
+	if p, ok := db.Price("AAPL", "$", date("2024/01/03")); !ok || p.String() != "$155" {
+		t.Errorf("got price %v, %v", p, ok)
+	}
+
+	if err := db.Parse("bad", strings.NewReader("P 2024/01/01 AAPL\n")); err == nil {
+		t.Errorf("expected an error for a price without an amount")
+	}
+}
+
+const holdings = `
+P 2024/03/01 EUR $1.10
+P 2024/03/01 AAPL $172.50
+
+2024/01/05 Buy
+    Assets:Brokerage    10 AAPL @ $150.00
+    Assets:Checking
+
+2024/02/05 Savings
+    Assets:Euro     EUR 100
+    Assets:Gold     2 OZ
+    Equity
+`
+
+func TestValuation(t *testing.T) {
+	pp := &Parser{}
+	if err := pp.Parse("holdings", strings.NewReader(holdings), false); err != nil {
+		t.Fatal(err)
+	}
+	for _, trans := range pp.Journal {
+		if err := trans.Balance(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := NewPriceDB()
+	db.AddDirectives(pp.Directives)
+	db.AddJournal(pp.Journal)
+
+	v := &Valuation{Prices: db, Commod: "$"}
+	accts := NewAccounts(pp.Journal)
+	lines, total, err := accts.Balance(&BalanceOpts{Pattern: "^assets", Valuation: v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteBalance(&buf, lines, total); err != nil {
+		t.Fatal(err)
+	}
+	want := `
+$335.00
+   2 OZ  Assets
+$1725.00    Brokerage
+$-1500.00    Checking
+$110.00    Euro
+   2 OZ    Gold
+---------
+$335.00
+   2 OZ
+`
+	if got := "\n" + buf.String(); strings.Join(strings.Fields(got), " ") != strings.Join(strings.Fields(want), " ") {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+
+	v.Date = date("2024/02/29")
+	value, rest := v.Value(accts.Find("Assets").Totals, v.Date)
+	if value.String() != "$0.00" || len(rest) != 2 {
+		t.Errorf("got value %v and remainder %v before any prices", value, rest)
+	}
+
+	v.Date = time.Time{}
+	reg, err := Register(pp.Journal, &RegisterOpts{Pattern: "brokerage|euro", Valuation: v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range reg {
+		got = append(got, line.Amount.String()+": "+line.Total.String())
+	}
+	if strings.Join(got, " | ") != "$1500.00: $1500.00 | EUR 100: $1500.00, EUR 100" {
+		t.Errorf("got register %q", strings.Join(got, " | "))
+	}
+}
+
+const period = `
+P 2024/01/15 EUR $1.10
+2024/01/20 Deposit
+    Assets:Euro  EUR 100
+    Equity
+
+P 2024/03/01 EUR $1.50
+2024/03/05 Deposit
+    Assets:Euro  EUR 100
+    Equity
+`
+
+func TestValuationPeriod(t *testing.T) {
+	pp := &Parser{}
+	if err := pp.Parse("period", strings.NewReader(period), false); err != nil {
+		t.Fatal(err)
+	}
+	db := NewPriceDB()
+	db.AddDirectives(pp.Directives)
+
+	// a report of January is valued at its end, before the March price
+	january := Filter(pp.Journal, func(t *Trans, it *Item) bool { return t.Date.Before(date("2024/02/01")) })
+	v := &Valuation{Prices: db, Commod: "$", Date: date("2024/01/31")}
+	lines, total, err := NewAccounts(january).Balance(&BalanceOpts{Pattern: "^assets", Valuation: v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) == 0 || total.String() != "$110.00" {
+		t.Errorf("got January total %v, want $110.00", total)
+	}
+
+	v.Date = time.Time{}
+	lines, total, err = NewAccounts(pp.Journal).Balance(&BalanceOpts{Pattern: "^assets", Valuation: v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) == 0 || total.String() != "$300.00" {
+		t.Errorf("got total %v at the latest prices, want $300.00", total)
+	}
+}
@@ -0,0 +1,132 @@
+package ledger
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	Minwidth = 4
+	Tabwidth = 4
+	Padding  = 2
+	Padchar  = ' '
+)
+
+// RegisterOpts controls which items a register report includes.
+type RegisterOpts struct {
+	// Pattern is a case-insensitive regular expression.  If non-empty,
+	// only items posted to accounts with matching names are reported.
+	Pattern string
+	// Aux orders and dates items by their auxiliary (effective) dates
+	// where they have them.
+	Aux bool
+	// Valuation, if set, values each item and running total in a single
+	// commodity, as of its Date or else as of each item's date.
+	Valuation *Valuation
+}
+
+// RegisterLine is a single item in a register report along with the
+// running total of all reported items up to and including it.
+type RegisterLine struct {
+	Date   time.Time
+	Trans  *Trans
+	Item   *Item
+	Amount Amount // the item's amount, valued if the report is
+	Total  Balance
+}
+
+// Register walks journal in date order and returns a line for every
+// matching item.  Items with the same date keep their journal order.
+func Register(journal []*Trans, opts *RegisterOpts) ([]RegisterLine, error) {
+	if opts == nil {
+		opts = &RegisterOpts{}
+	}
+	re, err := regexp.Compile("(?i)" + opts.Pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []RegisterLine
+	for _, t := range journal {
+		for _, it := range t.Items {
+			if it.Amount != nil && re.MatchString(it.Account) {
+				lines = append(lines, RegisterLine{Date: t.ItemDate(it, opts.Aux), Trans: t, Item: it})
+			}
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Date.Before(lines[j].Date)
+	})
+
+	total := Balance{}
+	for i := range lines {
+		line := &lines[i]
+		line.Amount = *line.Item.Amount
+		total.Add(line.Amount)
+		line.Total = total.Copy()
+
+		if v := opts.Valuation; v != nil {
+			date := v.Date
+			if date.IsZero() {
+				date = line.Date
+			}
+			if value, rest := v.Value(Balance{line.Amount.key(): line.Amount}, date); len(rest) == 0 {
+				line.Amount = value
+			}
+			line.Total = v.balance(line.Total, date)
+		}
+	}
+	return lines, nil
+}
+
+// WriteRegister writes lines as columns of date, payee, account, amount and
+// running total.  Running totals with several commodities continue on the
+// following lines.
+func WriteRegister(w io.Writer, lines []RegisterLine) error {
+	amtWidth, totWidth := 0, 0
+	for _, line := range lines {
+		amtWidth = maxWidth(amtWidth, line.Amount.String())
+		for _, s := range line.Total.Strings() {
+			totWidth = maxWidth(totWidth, s)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, Minwidth, Tabwidth, Padding, Padchar, 0)
+	for _, line := range lines {
+		totals := line.Total.Strings()
+		_, err := fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n",
+			line.Date.Format(dateFmt),
+			line.Trans.Descrip,
+			line.Item.Account,
+			padLeft(line.Amount.String(), amtWidth),
+			padLeft(totals[0], totWidth),
+		)
+		if err != nil {
+			return err
+		}
+		for _, s := range totals[1:] {
+			blank := strings.Repeat(" ", amtWidth)
+			if _, err := fmt.Fprintf(tw, "\t\t\t%v\t%v\n", blank, padLeft(s, totWidth)); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+func maxWidth(width int, s string) int {
+	if n := utf8.RuneCountInString(s); n > width {
+		return n
+	}
+	return width
+}
+
+func padLeft(s string, width int) string {
+	return strings.Repeat(" ", width-utf8.RuneCountInString(s)) + s
+}
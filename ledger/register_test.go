@@ -0,0 +1,75 @@
+package ledger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	lines, err := Register(balanced(t, journal2), &RegisterOpts{Pattern: "^assets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRegister(&buf, lines); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+2010/06/01  Grocery store  Assets:Checking   $-42.10  $-42.10
+2010/06/03  Gas station    Assets:Checking   $-30.00  $-72.10
+2010/06/03  Fill up        Assets:Fuel tank  -10 GAL  $-72.10
+                                                      -10 GAL
+2010/06/04  Card payment   Assets:Checking   $-12.50  $-84.60
+                                                      -10 GAL
+`
+	if got := "\n" + buf.String(); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+const auxJournal = `
+2024/01/05=2024/01/08 Check
+    Expenses:Rent       $900
+    Assets:Checking  ; [=2024/01/10]
+
+2024/01/06 Card
+    Expenses:Food       $20
+    Liabilities:Card
+`
+
+func TestRegisterAux(t *testing.T) {
+	journal := balanced(t, auxJournal)
+	if got := journal[0].AuxDate.Format(dateFmt); got != "2024/01/08" {
+		t.Errorf("got transaction aux date %v", got)
+	}
+	if got := journal[0].Items[1].AuxDate.Format(dateFmt); got != "2024/01/10" {
+		t.Errorf("got item aux date %v", got)
+	}
+
+	for _, aux := range []bool{false, true} {
+		lines, err := Register(journal, &RegisterOpts{Aux: aux})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, line := range lines {
+			got = append(got, line.Date.Format(dateFmt)+" "+line.Item.Account)
+		}
+		want := []string{"2024/01/05 Expenses:Rent", "2024/01/05 Assets:Checking", "2024/01/06 Expenses:Food", "2024/01/06 Liabilities:Card"}
+		if aux {
+			want = []string{"2024/01/06 Expenses:Food", "2024/01/06 Liabilities:Card", "2024/01/08 Expenses:Rent", "2024/01/10 Assets:Checking"}
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("aux=%v: got %v, want %v", aux, got, want)
+		}
+	}
+	for _, note := range []string{"[=foo]", "[=2024]", "[=2024/01/02/03]", "[=01/32]"} {
+		input := "2024/01/05 Check\n    Expenses:Rent  $900\n    Assets:Checking  ; " + note + "\n"
+		if _, err := Parse("aux", strings.NewReader(input)); err == nil {
+			t.Errorf("expected an error for the item aux date %v", note)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package ledger
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// tagsRe matches a word holding a run of colon separated tags, e.g.
+	// :food:travel:
+	tagsRe = regexp.MustCompile(`^:(?:[^:\s]+:)+$`)
+	// metaRe matches a "Key: value" note.
+	metaRe = regexp.MustCompile(`^([^:\s]+):(?:\s+(.*))?$`)
+)
+
+// addTags adds the tags and metadata found in note to *tags, creating the
+// map if needed.  Plain tags are given an empty value.
+func addTags(tags *map[string]string, note string) {
+	note = strings.TrimSpace(note)
+	if m := metaRe.FindStringSubmatch(note); m != nil {
+		addTag(tags, m[1], strings.TrimSpace(m[2]))
+		return
+	}
+	for _, word := range strings.Fields(note) {
+		if !tagsRe.MatchString(word) {
+			continue
+		}
+		for _, tag := range strings.Split(strings.Trim(word, ":"), ":") {
+			addTag(tags, tag, "")
+		}
+	}
+}
+
+// inheritTags gives each of t's items the transaction's tags that it doesn't
+// set itself.
+func (t *Trans) inheritTags() {
+	for _, it := range t.Items {
+		for k, v := range t.Tags {
+			if _, ok := it.Tags[k]; !ok {
+				addTag(&it.Tags, k, v)
+			}
+		}
+	}
+}
+
+func addTag(tags *map[string]string, k, v string) {
+	if *tags == nil {
+		*tags = map[string]string{}
+	}
+	(*tags)[k] = v
+}
@@ -2,6 +2,7 @@ package lex
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"unicode/utf8"
 )
@@ -44,6 +45,7 @@ type Lexer struct {
 	Pos    int        // current position in the input
 	Start  int        // start position of this Token
 	width  int        // width of last rune read from input
+	lines  []int      // offset of the start of each line
 	Tokens chan Token // channel of scanned Tokens
 }
 
@@ -53,8 +55,14 @@ func New(name, input string, start StateFn) *Lexer {
 	l := &Lexer{
 		name:   name,
 		Input:  input,
+		lines:  []int{0},
 		Tokens: make(chan Token, 100),
 	}
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\n' {
+			l.lines = append(l.lines, i+1)
+		}
+	}
 	go l.run(start)
 	return l
 }
@@ -84,13 +92,13 @@ func (l *Lexer) Next() rune {
 	return r
 }
 
-// Peek returns but does not consume the next rune in the input.
+// Peek returns but does not consume the next rune in the input.  A Backup
+// after Peek still steps back over the rune last returned by Next.
 func (l *Lexer) Peek() rune {
 	if int(l.Pos) >= len(l.Input) {
 		return EOF
 	}
-	r := l.Next()
-	l.Backup()
+	r, _ := utf8.DecodeRuneInString(l.Input[l.Pos:])
 	return r
 }
 
@@ -156,7 +164,22 @@ func (l *Lexer) AcceptRunNot(invalid string) int {
 
 // LineNumber reports which line we're on, based on the current position.
 func (l *Lexer) LineNumber() int {
-	return 1 + strings.Count(l.Input[:l.Pos], "\n")
+	line, _ := l.Position(l.Pos)
+	return line
+}
+
+// Name returns the name of the input being scanned.
+func (l *Lexer) Name() string { return l.name }
+
+// Position translates a byte offset into the input into a 1-based line and
+// column.
+func (l *Lexer) Position(pos int) (line, col int) {
+	if pos > len(l.Input) {
+		pos = len(l.Input)
+	}
+	line = sort.Search(len(l.lines), func(i int) bool { return l.lines[i] > pos })
+	col = 1 + utf8.RuneCountInString(l.Input[l.lines[line-1]:pos])
+	return line, col
 }
 
 // Errorf returns an error Token.
@@ -1,28 +1,88 @@
 package parse
 
-import "github.com/rwcarlsen/goledger/lex"
+import (
+	"fmt"
+
+	"github.com/rwcarlsen/goledger/lex"
+)
 
 type StateFn func(p *Parser) StateFn
 
+// Error describes a problem found in the input, positioned by the name of
+// the input and a 1-based line and column.
+type Error struct {
+	Name string
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v:%v:%v: %v", e.Name, e.Line, e.Col, e.Msg)
+}
+
+// ErrorList is a list of parse errors in the order they were found.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%v (and %v more errors)", l[0], len(l)-1)
+}
+
+// Err returns nil for an empty list and the list itself otherwise.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
 type Parser struct {
-	l      *lex.Lexer
-	toks   []lex.Token
-	pos    int
-	states []StateFn
+	l       *lex.Lexer
+	toks    []lex.Token
+	pos     int
+	states  []StateFn
+	errs    ErrorList
+	recover StateFn
 }
 
 func New(l *lex.Lexer, start StateFn) *Parser {
 	return &Parser{l: l, states: []StateFn{start}}
 }
 
-func (p *Parser) Run() {
-	for len(p.states) > 0 {
+// Recover sets the state the parser resumes from after an error.  With a
+// recovery state set, errors reported via Errorf are collected rather than
+// halting the parser.
+func (p *Parser) Recover(fn StateFn) { p.recover = fn }
+
+// Run runs the state machine until it finishes or a state reports an error
+// via Errorf or Report.  Without a recovery state, the first error is
+// returned as an *Error.  Otherwise every error found is returned in an
+// ErrorList.
+func (p *Parser) Run() error {
+	for len(p.states) > 0 && (p.recover != nil || len(p.errs) == 0) {
 		state := p.pop()
 		state = state(p)
 		if state != nil {
 			p.Push(state)
 		}
 	}
+
+	// let the lexer goroutine finish if we stopped early
+	for _ = range p.l.Tokens {
+	}
+
+	if p.recover != nil {
+		return p.errs.Err()
+	} else if len(p.errs) > 0 {
+		return p.errs[0]
+	}
+	return nil
 }
 
 func (p *Parser) Push(fn StateFn) { p.states = append(p.states, fn) }
@@ -34,10 +94,44 @@ func (p *Parser) pop() StateFn {
 	return fn
 }
 
+// Errorf records an error positioned at tok.  Pending states are discarded
+// and the parser either halts or resumes at the recovery state.
+func (p *Parser) Errorf(tok lex.Token, format string, args ...interface{}) StateFn {
+	line, col := p.Position(tok)
+	p.errs = append(p.errs, &Error{
+		Name: p.Name(),
+		Line: line,
+		Col:  col,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+	p.states = nil
+	if p.recover != nil {
+		p.states = append(p.states, p.recover)
+	}
+	return nil
+}
+
+// Report records errors found outside the parser's own input, such as in
+// another file it refers to.  Pending states are kept, so with a recovery
+// state set the parser carries on; otherwise it halts once the current
+// state returns.
+func (p *Parser) Report(errs ...*Error) {
+	p.errs = append(p.errs, errs...)
+}
+
+// Name returns the name of the input being parsed.
+func (p *Parser) Name() string { return p.l.Name() }
+
+// Position returns the 1-based line and column of tok in the input.
+func (p *Parser) Position(tok lex.Token) (line, col int) {
+	return p.l.Position(tok.Pos)
+}
+
 func (p *Parser) Next() lex.Token {
-	p.fill()
-	tok := p.toks[p.pos]
-	p.pos++
+	tok := p.Peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
 	return tok
 }
 
@@ -47,8 +141,13 @@ func (p *Parser) Backup() {
 	}
 }
 
+// Peek returns but does not consume the next token.  Once the lexer is
+// exhausted, an EOF token is returned.
 func (p *Parser) Peek() lex.Token {
 	p.fill()
+	if p.pos >= len(p.toks) {
+		return lex.Token{Type: lex.TokEOF, Pos: len(p.l.Input)}
+	}
 	return p.toks[p.pos]
 }
 
@@ -58,9 +157,8 @@ func (p *Parser) fill() {
 	if p.pos+1 >= len(p.toks) {
 		need := p.pos - len(p.toks) + 10
 		for i := 0; i < need; i++ {
-			tok := <-p.l.Tokens
-			blank := lex.Token{}
-			if tok == blank {
+			tok, ok := <-p.l.Tokens
+			if !ok {
 				return
 			}
 			p.toks = append(p.toks, tok)
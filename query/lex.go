@@ -0,0 +1,113 @@
+package query
+
+import (
+	"strings"
+
+	"github.com/rwcarlsen/goledger/lex"
+)
+
+const (
+	tokWord lex.TokType = iota
+	tokRegex
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+var tokNames = map[lex.TokType]string{
+	lex.TokError: "Error",
+	lex.TokEOF:   "EOF",
+	tokWord:      "Word",
+	tokRegex:     "Regex",
+	tokOp:        "Op",
+	tokLParen:    "LParen",
+	tokRParen:    "RParen",
+}
+
+const (
+	space   = " \t\r\n"
+	opchars = "<>=!"
+	special = space + opchars + "()\""
+)
+
+func lexQuery(l *lex.Lexer) lex.StateFn {
+	l.AcceptRun(space)
+	l.Ignore()
+
+	switch r := l.Peek(); {
+	case r == lex.EOF:
+		l.Emit(lex.TokEOF)
+		return nil
+	case r == '(':
+		l.Next()
+		l.Emit(tokLParen)
+	case r == ')':
+		l.Next()
+		l.Emit(tokRParen)
+	case r == '/':
+		return lexRegex
+	case r == '"':
+		return lexQuoted
+	case strings.ContainsRune(opchars, r):
+		return lexOp
+	default:
+		return lexWord
+	}
+	return lexQuery
+}
+
+// lexRegex scans a /regex/ emitting the text between the slashes.  A slash
+// inside the expression can be escaped with a backslash.
+func lexRegex(l *lex.Lexer) lex.StateFn {
+	l.Next()
+	l.Ignore()
+	for {
+		switch l.Next() {
+		case '\\':
+			l.Next()
+		case '/':
+			l.Backup()
+			l.Emit(tokRegex)
+			l.Next()
+			l.Ignore()
+			return lexQuery
+		case lex.EOF:
+			return l.Errorf("unterminated regular expression")
+		}
+	}
+}
+
+// lexQuoted scans a double quoted word, which may contain spaces.
+func lexQuoted(l *lex.Lexer) lex.StateFn {
+	l.Next()
+	l.Ignore()
+	l.AcceptRunNot("\"")
+	if l.Peek() != '"' {
+		return l.Errorf("unterminated quoted string")
+	}
+	l.Emit(tokWord)
+	l.Next()
+	l.Ignore()
+	return lexQuery
+}
+
+// lexOp scans a comparison operator.  A lone '!' is a word (the pending
+// status) rather than an operator.
+func lexOp(l *lex.Lexer) lex.StateFn {
+	l.AcceptRun(opchars)
+	switch l.Input[l.Start:l.Pos] {
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+		l.Emit(tokOp)
+	case "!":
+		l.Emit(tokWord)
+	default:
+		return l.Errorf("invalid operator '%v'", l.Input[l.Start:l.Pos])
+	}
+	return lexQuery
+}
+
+func lexWord(l *lex.Lexer) lex.StateFn {
+	l.AcceptRunNot(special)
+	l.Emit(tokWord)
+	return lexQuery
+}
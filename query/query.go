@@ -0,0 +1,393 @@
+// Package query implements an expression language for selecting
+// transaction items in reports.  Expressions combine terms with "and", "or",
+// "not" and parentheses.  Terms are:
+//
+//	account PATTERN          the item's account (the default for a bare PATTERN)
+//	payee PATTERN            the transaction's payee
+//	code PATTERN             the transaction's code, e.g. a check number
+//	note PATTERN             any of the transaction or item notes
+//	commodity PATTERN        the item's commodity (bare words match exactly)
+//	date OP DATE             the transaction date, e.g. date >= 2024/01/01
+//	auxdate OP DATE          the item's auxiliary date, else its actual date
+//	amount OP NUMBER         the item's amount, e.g. amount > 100
+//	status STATUS            the item's status: *, ! or "" for none
+//	tag(KEY)                 the item has the tag or metadata KEY
+//	tag(KEY)=PATTERN         the value of the item's KEY matches PATTERN
+//
+// A PATTERN is a case-insensitive regular expression written either as a
+// bare word or between slashes (/like this/).  OP is one of = != < <= > >=.
+// Adjacent terms without an operator between them are or'ed together.
+package query
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	"github.com/rwcarlsen/goledger/ledger"
+	"github.com/rwcarlsen/goledger/lex"
+)
+
+// Pred reports whether an item of a transaction is selected.
+type Pred func(t *ledger.Trans, it *ledger.Item) bool
+
+// All selects every item.
+func All(t *ledger.Trans, it *ledger.Item) bool { return true }
+
+// dateFmts are the layouts accepted for dates in queries.
+var dateFmts = []string{"2006/01/02", "2006-01-02", "2006/1/2", "2006-1-2"}
+
+// Error describes a problem with a query expression.
+type Error struct {
+	Pos int // byte offset of the problem in the query
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("query: %v (at offset %v)", e.Msg, e.Pos)
+}
+
+// Parse compiles a query expression.  An empty expression selects
+// everything.
+func Parse(expr string) (Pred, error) {
+	var toks []lex.Token
+	for tok := range lex.New("query", expr, lexQuery).Tokens {
+		toks = append(toks, tok)
+	}
+	if n := len(toks); n == 0 || toks[n-1].Type != lex.TokEOF && toks[n-1].Type != lex.TokError {
+		toks = append(toks, lex.Token{Type: lex.TokEOF, Pos: len(expr)})
+	}
+
+	p := &parser{toks: toks}
+	if p.peek().Type == lex.TokEOF {
+		return All, nil
+	}
+	pred, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	} else if tok := p.next(); tok.Type != lex.TokEOF {
+		return nil, p.unexpected(tok)
+	}
+	return pred, nil
+}
+
+type parser struct {
+	toks []lex.Token
+	pos  int
+}
+
+func (p *parser) peek() lex.Token { return p.toks[p.pos] }
+
+func (p *parser) next() lex.Token {
+	tok := p.toks[p.pos]
+	if p.pos < len(p.toks)-1 {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) unexpected(tok lex.Token) error {
+	switch tok.Type {
+	case lex.TokError:
+		return &Error{tok.Pos, tok.Val}
+	case lex.TokEOF:
+		return &Error{tok.Pos, "unexpected end of query"}
+	}
+	return &Error{tok.Pos, fmt.Sprintf("unexpected %v '%v'", tokNames[tok.Type], tok.Val)}
+}
+
+// isKeyword reports whether tok is the bare word kw.
+func isKeyword(tok lex.Token, kw string) bool {
+	return tok.Type == tokWord && tok.Val == kw
+}
+
+// startsTerm reports whether tok can begin another term, in which case it is
+// implicitly or'ed with the previous one.
+func startsTerm(tok lex.Token) bool {
+	switch tok.Type {
+	case tokLParen, tokRegex:
+		return true
+	case tokWord:
+		return tok.Val != "and" && tok.Val != "or"
+	}
+	return false
+}
+
+func (p *parser) parseOr() (Pred, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if isKeyword(p.peek(), "or") {
+			p.next()
+		} else if !startsTerm(p.peek()) {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = or(left, right)
+	}
+}
+
+func (p *parser) parseAnd() (Pred, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for isKeyword(p.peek(), "and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = and(left, right)
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Pred, error) {
+	if !isKeyword(p.peek(), "not") {
+		return p.parsePrimary()
+	}
+	p.next()
+	pred, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return func(t *ledger.Trans, it *ledger.Item) bool { return !pred(t, it) }, nil
+}
+
+func (p *parser) parsePrimary() (Pred, error) {
+	tok := p.next()
+	switch tok.Type {
+	case tokLParen:
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		} else if tok := p.next(); tok.Type != tokRParen {
+			return nil, p.unexpected(tok)
+		}
+		return pred, nil
+	case tokRegex:
+		return p.account(tok)
+	case tokWord:
+		if term, ok := terms[tok.Val]; ok {
+			return term(p)
+		}
+		return p.account(tok)
+	}
+	return nil, p.unexpected(tok)
+}
+
+var terms map[string]func(p *parser) (Pred, error)
+
+func init() {
+	terms = map[string]func(p *parser) (Pred, error){
+		"account": func(p *parser) (Pred, error) { return p.account(p.next()) },
+		"payee": func(p *parser) (Pred, error) {
+			return p.regexTerm(func(re *regexp.Regexp, t *ledger.Trans, it *ledger.Item) bool {
+				return re.MatchString(t.Descrip)
+			})
+		},
+		"code": func(p *parser) (Pred, error) {
+			return p.regexTerm(func(re *regexp.Regexp, t *ledger.Trans, it *ledger.Item) bool {
+				return re.MatchString(t.Code)
+			})
+		},
+		"note": func(p *parser) (Pred, error) {
+			return p.regexTerm(func(re *regexp.Regexp, t *ledger.Trans, it *ledger.Item) bool {
+				return matchAny(re, t.Notes) || matchAny(re, it.Notes)
+			})
+		},
+		"commodity": (*parser).commodity,
+		"status":    (*parser).status,
+		"date":      func(p *parser) (Pred, error) { return p.date(false) },
+		"auxdate":   func(p *parser) (Pred, error) { return p.date(true) },
+		"amount":    (*parser).amount,
+		"tag":       (*parser).tag,
+	}
+}
+
+func (p *parser) pattern(tok lex.Token) (*regexp.Regexp, error) {
+	if tok.Type != tokWord && tok.Type != tokRegex {
+		return nil, p.unexpected(tok)
+	}
+	re, err := regexp.Compile("(?i)" + tok.Val)
+	if err != nil {
+		return nil, &Error{tok.Pos, err.Error()}
+	}
+	return re, nil
+}
+
+func (p *parser) account(tok lex.Token) (Pred, error) {
+	re, err := p.pattern(tok)
+	if err != nil {
+		return nil, err
+	}
+	return func(t *ledger.Trans, it *ledger.Item) bool { return re.MatchString(it.Account) }, nil
+}
+
+func (p *parser) regexTerm(match func(re *regexp.Regexp, t *ledger.Trans, it *ledger.Item) bool) (Pred, error) {
+	re, err := p.pattern(p.next())
+	if err != nil {
+		return nil, err
+	}
+	return func(t *ledger.Trans, it *ledger.Item) bool { return match(re, t, it) }, nil
+}
+
+// commodity matches a bare word exactly since commodities are often
+// symbols like "$" that mean something else in a regular expression.
+func (p *parser) commodity() (Pred, error) {
+	tok := p.peek()
+	if tok.Type != tokWord {
+		return p.regexTerm(func(re *regexp.Regexp, t *ledger.Trans, it *ledger.Item) bool {
+			return it.Amount != nil && re.MatchString(it.Amount.Commod)
+		})
+	}
+	p.next()
+	return func(t *ledger.Trans, it *ledger.Item) bool {
+		return it.Amount != nil && it.Amount.Commod == tok.Val
+	}, nil
+}
+
+func (p *parser) tag() (Pred, error) {
+	if tok := p.next(); tok.Type != tokLParen {
+		return nil, p.unexpected(tok)
+	}
+	key := p.next()
+	if key.Type != tokWord {
+		return nil, p.unexpected(key)
+	}
+	if tok := p.next(); tok.Type != tokRParen {
+		return nil, p.unexpected(tok)
+	}
+
+	has := func(t *ledger.Trans, it *ledger.Item) bool {
+		_, ok := it.Tags[key.Val]
+		return ok
+	}
+	if tok := p.peek(); tok.Type != tokOp {
+		return has, nil
+	} else if tok.Val != "=" && tok.Val != "!=" {
+		return nil, p.unexpected(tok)
+	}
+
+	op := p.next().Val
+	pred, err := p.regexTerm(func(re *regexp.Regexp, t *ledger.Trans, it *ledger.Item) bool {
+		v, ok := it.Tags[key.Val]
+		return ok && re.MatchString(v)
+	})
+	if err != nil || op == "=" {
+		return pred, err
+	}
+	return func(t *ledger.Trans, it *ledger.Item) bool { return !pred(t, it) }, nil
+}
+
+func (p *parser) status() (Pred, error) {
+	tok := p.next()
+	if tok.Type != tokWord {
+		return nil, p.unexpected(tok)
+	}
+	want := tok.Val
+	return func(t *ledger.Trans, it *ledger.Item) bool {
+		status := it.Status
+		if status == "" {
+			status = t.Status
+		}
+		return status == want
+	}, nil
+}
+
+func (p *parser) op() (string, error) {
+	tok := p.next()
+	if tok.Type != tokOp {
+		return "", p.unexpected(tok)
+	}
+	return tok.Val, nil
+}
+
+// compare applies op to the result of a three-way comparison.
+func compare(op string, cmp int) bool {
+	switch op {
+	case "=", "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	panic("unknown operator " + op)
+}
+
+func (p *parser) date(aux bool) (Pred, error) {
+	op, err := p.op()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.next()
+	if tok.Type != tokWord {
+		return nil, p.unexpected(tok)
+	}
+
+	var date time.Time
+	for _, layout := range dateFmts {
+		if date, err = time.Parse(layout, tok.Val); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, &Error{tok.Pos, fmt.Sprintf("invalid date '%v'", tok.Val)}
+	}
+
+	return func(t *ledger.Trans, it *ledger.Item) bool {
+		d := t.ItemDate(it, aux)
+		day := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+		return compare(op, day.Compare(date))
+	}, nil
+}
+
+func (p *parser) amount() (Pred, error) {
+	op, err := p.op()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.next()
+	if tok.Type != tokWord {
+		return nil, p.unexpected(tok)
+	}
+	want, ok := new(big.Rat).SetString(tok.Val)
+	if !ok {
+		return nil, &Error{tok.Pos, fmt.Sprintf("invalid amount '%v'", tok.Val)}
+	}
+
+	return func(t *ledger.Trans, it *ledger.Item) bool {
+		return it.Amount != nil && compare(op, it.Amount.Qty.Cmp(want))
+	}, nil
+}
+
+func and(a, b Pred) Pred {
+	return func(t *ledger.Trans, it *ledger.Item) bool { return a(t, it) && b(t, it) }
+}
+
+func or(a, b Pred) Pred {
+	return func(t *ledger.Trans, it *ledger.Item) bool { return a(t, it) || b(t, it) }
+}
+
+func matchAny(re *regexp.Regexp, notes []string) bool {
+	for _, note := range notes {
+		if re.MatchString(note) {
+			return true
+		}
+	}
+	return false
+}
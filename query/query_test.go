@@ -0,0 +1,82 @@
+package query
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rwcarlsen/goledger/ledger"
+)
+
+const journal = `
+10/06/01 * Walgreens
+    ; :health:
+    Expenses:Food:Snacks         $4.50  ; Receipt: 1234
+    Assets:Checking
+
+10/06/02 (1042) Grocery store
+    Expenses:Food:Groceries      $142.10
+    Assets:Checking
+
+10/06/03 Fill up
+    Expenses:Auto:Fuel           10 GAL
+    Assets:Fuel tank
+`
+
+var queryTests = []struct {
+	expr string
+	want []string // matching accounts
+}{
+	{"", []string{"Expenses:Food:Snacks", "Assets:Checking", "Expenses:Food:Groceries", "Assets:Checking", "Expenses:Auto:Fuel", "Assets:Fuel tank"}},
+	{"expenses:food", []string{"Expenses:Food:Snacks", "Expenses:Food:Groceries"}},
+	{"food fuel", []string{"Expenses:Food:Snacks", "Expenses:Food:Groceries", "Expenses:Auto:Fuel", "Assets:Fuel tank"}},
+	{"expenses:food and not payee /walgreens/", []string{"Expenses:Food:Groceries"}},
+	{"date >= 2010/06/02 and amount > 100 and commodity $", []string{"Expenses:Food:Groceries"}},
+	{"status * and ^assets", []string{"Assets:Checking"}},
+	{"not (food or checking) and commodity /^gal$/", []string{"Expenses:Auto:Fuel", "Assets:Fuel tank"}},
+	{`payee "grocery store" and amount < 0`, []string{"Assets:Checking"}},
+	{"code ^1042$ and food", []string{"Expenses:Food:Groceries"}},
+	{"tag(health)", []string{"Expenses:Food:Snacks", "Assets:Checking"}},
+	{"tag(Receipt)=^12", []string{"Expenses:Food:Snacks"}},
+	{"tag(health) and tag(Receipt) != /34$/", []string{"Assets:Checking"}},
+}
+
+func TestQuery(t *testing.T) {
+	trans, err := ledger.Parse("journal", strings.NewReader(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range trans {
+		if err := tr.Balance(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range queryTests {
+		pred, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("'%v': %v", test.expr, err)
+			continue
+		}
+		var got []string
+		for _, tr := range trans {
+			for _, it := range tr.Items {
+				if pred(tr, it) {
+					got = append(got, it.Account)
+				}
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("'%v': got %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, expr := range []string{"date >= bogus", "amount ~ 3", "(food", "/unterminated", "payee", "food and"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("'%v': expected error", expr)
+		} else {
+			t.Logf("'%v': %v", expr, err)
+		}
+	}
+}